@@ -0,0 +1,184 @@
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var ErrInvalidArgsEscape = errors.New("http1: invalid percent-escape in form data")
+
+var bFormURLEncoded = []byte("application/x-www-form-urlencoded")
+
+// Args are the decoded key/value pairs of a query string or of an
+// application/x-www-form-urlencoded body, in their original order.
+type Args struct {
+	kvs []argsKV
+	buf []byte // 解码后的key和value，kvs 指向这里
+	raw []byte // PostArgs 读取的body
+}
+
+type argsKV struct {
+	key, value []byte
+}
+
+// Reset removes all the pairs, keeping the buffers for reuse.
+func (a *Args) Reset() {
+	a.kvs = a.kvs[:0]
+	a.buf = a.buf[:0]
+	a.raw = a.raw[:0]
+}
+
+// Parse decodes the pairs of b ('+' is a space) and replaces those of a.
+func (a *Args) Parse(b []byte) error {
+	a.kvs = a.kvs[:0]
+	// 解码之后不会变长，预先分配足够的空间，kvs 里的切片不会因为扩容失效
+	if cap(a.buf) < len(b) {
+		a.buf = make([]byte, 0, len(b))
+	}
+	a.buf = a.buf[:0]
+
+	for len(b) > 0 {
+		var pair []byte
+		if i := bytes.IndexByte(b, '&'); i == -1 {
+			pair, b = b, nil
+		} else {
+			pair, b = b[:i], b[i+1:]
+		}
+		if len(pair) == 0 {
+			continue
+		}
+		var key, value []byte
+		if i := bytes.IndexByte(pair, '='); i == -1 {
+			key = pair
+		} else {
+			key, value = pair[:i], pair[i+1:]
+		}
+
+		var kv argsKV
+		var err error
+		if kv.key, err = a.unescape(key); err != nil {
+			return err
+		}
+		if kv.value, err = a.unescape(value); err != nil {
+			return err
+		}
+		a.kvs = append(a.kvs, kv)
+	}
+	return nil
+}
+
+// unescape 把 s 解码追加到 a.buf，返回解码的部分
+func (a *Args) unescape(s []byte) ([]byte, error) {
+	start := len(a.buf)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '+':
+			a.buf = append(a.buf, ' ')
+		case '%':
+			if i+2 >= len(s) {
+				return nil, ErrInvalidArgsEscape
+			}
+			hi, ok1 := unhex(s[i+1])
+			lo, ok2 := unhex(s[i+2])
+			if !ok1 || !ok2 {
+				return nil, ErrInvalidArgsEscape
+			}
+			a.buf = append(a.buf, hi<<4|lo)
+			i += 2
+		default:
+			a.buf = append(a.buf, c)
+		}
+	}
+	return a.buf[start:len(a.buf):len(a.buf)], nil
+}
+
+// Len returns the number of pairs.
+func (a *Args) Len() int {
+	return len(a.kvs)
+}
+
+// Peek returns the value of the first pair named key, or nil.
+func (a *Args) Peek(key string) []byte {
+	for _, kv := range a.kvs {
+		if b2s(kv.key) == key {
+			return kv.value
+		}
+	}
+	return nil
+}
+
+// PeekMulti returns the values of all the pairs named key.
+func (a *Args) PeekMulti(key string) (values [][]byte) {
+	for _, kv := range a.kvs {
+		if b2s(kv.key) == key {
+			values = append(values, kv.value)
+		}
+	}
+	return
+}
+
+// Has reports whether a pair named key exists, possibly with an empty value.
+func (a *Args) Has(key string) bool {
+	for _, kv := range a.kvs {
+		if b2s(kv.key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// VisitAll calls f with each pair in order until f returns false.
+func (a *Args) VisitAll(f func(key, value []byte) bool) {
+	for _, kv := range a.kvs {
+		if !f(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// PostArgs reads and parses an application/x-www-form-urlencoded body of at
+// most limit bytes, from DecodedBody. For other content types the Args are
+// empty. The result is kept until the next request is read into m.
+func (m *Request) PostArgs(limit int64) (*Args, error) {
+	if m.postRead {
+		return &m.postArgs, m.postErr
+	}
+	m.postRead = true
+
+	ct := m.Header.Get(bContentType)
+	if i := bytes.IndexByte(ct, ';'); i != -1 {
+		ct = ct[:i]
+	}
+	if !bytes.EqualFold(bytes.TrimSpace(ct), bFormURLEncoded) {
+		return &m.postArgs, nil
+	}
+
+	a := &m.postArgs
+	if m.postErr = readAllLimit(&a.raw, m.DecodedBody(), limit); m.postErr == nil {
+		m.postErr = a.Parse(a.raw)
+	}
+	return a, m.postErr
+}
+
+// readAllLimit 把 r 读到 *dst，超过 limit 时返回 ErrBodyTooLarge
+func readAllLimit(dst *[]byte, r io.Reader, limit int64) error {
+	b := (*dst)[:0]
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		*dst = b
+		if int64(len(b)) > limit {
+			return ErrBodyTooLarge
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
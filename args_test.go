@@ -0,0 +1,66 @@
+package http1
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Args_Parse(t *testing.T) {
+	a := &Args{}
+	e := a.Parse([]byte("a=1&b=x+y%21&&a=2&flag&c=&%E4%B8%AD=%e6%96%87"))
+	if !assert.Nil(t, e) {
+		return
+	}
+	assert.Equal(t, 6, a.Len())
+	assert.Equal(t, "1", string(a.Peek("a")))
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2")}, a.PeekMulti("a"))
+	assert.Equal(t, "x y!", string(a.Peek("b")))
+	assert.True(t, a.Has("flag"))
+	assert.True(t, a.Has("c"))
+	assert.False(t, a.Has("d"))
+	assert.Nil(t, a.Peek("d"))
+	assert.Equal(t, "文", string(a.Peek("中")))
+
+	var keys []string
+	a.VisitAll(func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return len(keys) < 3
+	})
+	assert.Equal(t, []string{"a", "b", "a"}, keys)
+
+	for _, s := range []string{"a=%", "a=%2", "%zz=1"} {
+		assert.Equal(t, ErrInvalidArgsEscape, a.Parse([]byte(s)), s)
+	}
+
+	// 复用缓冲区时不分配内存
+	b := []byte("x=1&y=2")
+	a.Parse(b)
+	allocs := testing.AllocsPerRun(100, func() {
+		a.Parse(b)
+		a.Peek("y")
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func Test_Request_PostArgs(t *testing.T) {
+	req := readTestRequest(t, "POST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nTransfer-Encoding: chunked\r\n\r\n4\r\na=1&\r\n3\r\nb=2\r\n0\r\n\r\n")
+	defer ReleaseRequest(req)
+	a, e := req.PostArgs(1024)
+	if assert.Nil(t, e) {
+		assert.Equal(t, "1", string(a.Peek("a")))
+		assert.Equal(t, "2", string(a.Peek("b")))
+	}
+	a2, _ := req.PostArgs(1024)
+	assert.True(t, a == a2)
+
+	req2 := readTestRequest(t, "POST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\na=12345")
+	defer ReleaseRequest(req2)
+	_, e = req2.PostArgs(6)
+	assert.Equal(t, ErrBodyTooLarge, e)
+
+	req3 := readTestRequest(t, "POST / HTTP/1.1\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\na=1")
+	defer ReleaseRequest(req3)
+	a, e = req3.PostArgs(1024)
+	assert.Nil(t, e)
+	assert.Equal(t, 0, a.Len())
+}
@@ -0,0 +1,397 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCredentials  = errors.New("http1: no credentials")
+	ErrBadCredentials = errors.New("http1: malformed credentials")
+)
+
+var bAuthorization = []byte("Authorization")
+
+// 407 时最多读取丢弃的请求body，超过时关闭连接
+const maxAuthDiscard = 64 << 10
+
+// DefaultNonceTTL is used when DigestAuth.NonceTTL is 0.
+const DefaultNonceTTL = 5 * time.Minute
+
+// Credentials are parsed from an Authorization or Proxy-Authorization header.
+type Credentials struct {
+	Scheme   string // "Basic", "Digest" or another scheme as sent
+	Username string
+	Password string // Basic only
+
+	// Params are the auth-params of Digest (and other schemes that use
+	// them), with lower-case names and unquoted values.
+	Params map[string]string
+}
+
+// Credentials parses the Authorization header.
+func (h *RequestHeader) Credentials() (*Credentials, error) {
+	return parseCredentials(h.Get(bAuthorization))
+}
+
+// ProxyCredentials parses the Proxy-Authorization header.
+func (h *RequestHeader) ProxyCredentials() (*Credentials, error) {
+	return parseCredentials(h.Get(bProxyAuthorization))
+}
+
+func parseCredentials(value []byte) (*Credentials, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return nil, ErrNoCredentials
+	}
+	i := bytes.IndexByte(value, ' ')
+	if i == -1 {
+		return &Credentials{Scheme: string(value)}, nil
+	}
+	c := &Credentials{Scheme: string(value[:i])}
+	rest := bytes.TrimSpace(value[i+1:])
+
+	if strings.EqualFold(c.Scheme, "Basic") {
+		// token68: base64(user ":" password)
+		b, err := base64.StdEncoding.DecodeString(string(rest))
+		if err != nil {
+			return nil, ErrBadCredentials
+		}
+		j := bytes.IndexByte(b, ':')
+		if j == -1 {
+			return nil, ErrBadCredentials
+		}
+		c.Scheme, c.Username, c.Password = "Basic", string(b[:j]), string(b[j+1:])
+		return c, nil
+	}
+
+	params, err := parseAuthParams(rest)
+	if err != nil {
+		return nil, err
+	}
+	c.Params = params
+	if strings.EqualFold(c.Scheme, "Digest") {
+		c.Scheme = "Digest"
+		c.Username = params["username"]
+	}
+	return c, nil
+}
+
+// parseAuthParams 解析 #auth-param：name=token 或 name="quoted-string"，用逗号分隔
+func parseAuthParams(b []byte) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		b = bytes.TrimLeft(b, " \t,")
+		if len(b) == 0 {
+			return params, nil
+		}
+		i := bytes.IndexByte(b, '=')
+		if i <= 0 {
+			return nil, ErrBadCredentials
+		}
+		name := strings.ToLower(string(bytes.TrimSpace(b[:i])))
+		b = bytes.TrimLeft(b[i+1:], " \t")
+
+		var value []byte
+		if len(b) > 0 && b[0] == '"' {
+			j := 1
+			for ; j < len(b) && b[j] != '"'; j++ {
+				if b[j] == '\\' && j+1 < len(b) {
+					j++
+				}
+				value = append(value, b[j])
+			}
+			if j == len(b) {
+				return nil, ErrBadCredentials
+			}
+			b = b[j+1:]
+		} else {
+			j := bytes.IndexByte(b, ',')
+			if j == -1 {
+				j = len(b)
+			}
+			value, b = bytes.TrimSpace(b[:j]), b[j:]
+		}
+		params[name] = string(value)
+	}
+}
+
+// CredentialStore gives the passwords of users.
+type CredentialStore interface {
+	Password(user string) (password string, ok bool)
+}
+
+// StaticCredentials is a CredentialStore mapping users to passwords.
+type StaticCredentials map[string]string
+
+func (s StaticCredentials) Password(user string) (string, bool) {
+	p, ok := s[user]
+	return p, ok
+}
+
+// Authenticator checks the credentials sent with a request.
+type Authenticator interface {
+	// Authenticate returns the user of cred, which may be nil when the
+	// request has none, or ok false.
+	Authenticate(h *RequestHeader, cred *Credentials) (user string, ok bool)
+
+	// Challenges returns the challenges to send in Proxy-Authenticate (or
+	// WWW-Authenticate) after cred failed.
+	Challenges(cred *Credentials) []string
+}
+
+// BasicAuth is the Basic scheme (RFC 7617).
+type BasicAuth struct {
+	Realm string
+	Store CredentialStore
+}
+
+func (a *BasicAuth) Authenticate(h *RequestHeader, cred *Credentials) (string, bool) {
+	if cred == nil || cred.Scheme != "Basic" {
+		return "", false
+	}
+	password, ok := a.Store.Password(cred.Username)
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(cred.Password)) != 1 {
+		return "", false
+	}
+	return cred.Username, true
+}
+
+func (a *BasicAuth) Challenges(cred *Credentials) []string {
+	return []string{`Basic realm=` + quoteAuthParam(a.Realm) + `, charset="UTF-8"`}
+}
+
+// DigestAuth is the Digest scheme (RFC 7616) with qop=auth and the
+// algorithms SHA-256 and MD5, plain or -sess. Nonces expire after NonceTTL
+// and each nonce count (nc) is accepted only once.
+//
+// Nonces are signed timestamps, so challenges keep no state; only nonces
+// that authenticated a request are remembered, to check their nc.
+type DigestAuth struct {
+	Realm    string
+	Store    CredentialStore
+	NonceTTL time.Duration
+
+	once   sync.Once
+	key    [32]byte // nonce 的 HMAC 密钥
+	opaque string
+
+	mu     sync.Mutex
+	nonces map[string]*digestNonce // 认证成功过的 nonce
+	swept  time.Time
+}
+
+type digestNonce struct {
+	issued time.Time
+	nc     uint64 // 用过的最大 nonce count
+}
+
+// nonceMACSize 是 nonce 中 HMAC 截断后的长度
+const nonceMACSize = 16
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	"MD5":          md5.New,
+	"MD5-sess":     md5.New,
+	"SHA-256":      sha256.New,
+	"SHA-256-sess": sha256.New,
+}
+
+func (a *DigestAuth) Authenticate(h *RequestHeader, cred *Credentials) (string, bool) {
+	if cred == nil || cred.Scheme != "Digest" {
+		return "", false
+	}
+	p := cred.Params
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	newHash, ok := digestAlgorithms[algorithm]
+	if !ok || p["qop"] != "auth" || p["realm"] != a.Realm || p["uri"] != string(h.RequestURI) {
+		return "", false
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil {
+		return "", false
+	}
+	password, ok := a.Store.Password(cred.Username)
+	if !ok {
+		return "", false
+	}
+
+	hexHash := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := hexHash(cred.Username + ":" + a.Realm + ":" + password)
+	if strings.HasSuffix(algorithm, "-sess") {
+		ha1 = hexHash(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := hexHash(string(h.Method) + ":" + p["uri"])
+	expected := hexHash(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(p["response"])) != 1 {
+		return "", false
+	}
+	if !a.useNonce(p["nonce"], nc) {
+		return "", false
+	}
+	return cred.Username, true
+}
+
+// Challenges returns a challenge for SHA-256 and one for MD5, with a new
+// nonce. stale=true tells the client that only the nonce of cred expired.
+func (a *DigestAuth) Challenges(cred *Credentials) []string {
+	nonce, opaque := a.newNonce()
+	stale := ""
+	if cred != nil && cred.Scheme == "Digest" && !a.validNonce(cred.Params["nonce"]) {
+		stale = ", stale=true"
+	}
+	challenge := func(algorithm string) string {
+		return `Digest realm=` + quoteAuthParam(a.Realm) + `, qop="auth", algorithm=` + algorithm +
+			`, nonce="` + nonce + `", opaque="` + opaque + `"` + stale
+	}
+	return []string{challenge("SHA-256"), challenge("MD5")}
+}
+
+func (a *DigestAuth) ttl() time.Duration {
+	if a.NonceTTL > 0 {
+		return a.NonceTTL
+	}
+	return DefaultNonceTTL
+}
+
+func (a *DigestAuth) init() {
+	a.once.Do(func() {
+		rand.Read(a.key[:])
+		var b [16]byte
+		rand.Read(b[:])
+		a.opaque = hex.EncodeToString(b[:])
+	})
+}
+
+func (a *DigestAuth) newNonce() (nonce, opaque string) {
+	a.init()
+	return a.nonceAt(time.Now()), a.opaque
+}
+
+// nonceAt 返回 hex(时间戳 + HMAC(时间戳))
+func (a *DigestAuth) nonceAt(t time.Time) string {
+	var b [8 + nonceMACSize]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()))
+	mac := hmac.New(sha256.New, a.key[:])
+	mac.Write(b[:8])
+	copy(b[8:], mac.Sum(nil))
+	return hex.EncodeToString(b[:])
+}
+
+// nonceIssued 验证 nonce 是这里发出的，返回发出的时间
+func (a *DigestAuth) nonceIssued(nonce string) (time.Time, bool) {
+	a.init()
+	b, err := hex.DecodeString(nonce)
+	if err != nil || len(b) != 8+nonceMACSize {
+		return time.Time{}, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	if !hmac.Equal([]byte(a.nonceAt(issued)), []byte(nonce)) {
+		return time.Time{}, false
+	}
+	return issued, true
+}
+
+func (a *DigestAuth) validNonce(nonce string) bool {
+	issued, ok := a.nonceIssued(nonce)
+	return ok && time.Since(issued) <= a.ttl()
+}
+
+// useNonce 检查 nonce 没有过期，并且 nc 比之前用过的大（防止重放）
+func (a *DigestAuth) useNonce(nonce string, nc uint64) bool {
+	issued, ok := a.nonceIssued(nonce)
+	now := time.Now()
+	if !ok || now.Sub(issued) > a.ttl() {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]*digestNonce)
+	}
+	// 每个TTL清理一次过期的
+	if now.Sub(a.swept) > a.ttl() {
+		a.swept = now
+		for n, dn := range a.nonces {
+			if now.Sub(dn.issued) > a.ttl() {
+				delete(a.nonces, n)
+			}
+		}
+	}
+	dn := a.nonces[nonce]
+	if dn == nil {
+		dn = &digestNonce{issued: issued}
+		a.nonces[nonce] = dn
+	} else if nc <= dn.nc {
+		return false
+	}
+	dn.nc = nc
+	return true
+}
+
+// MultiAuth accepts credentials of any of its authenticators and offers the
+// challenges of all of them, e.g. Digest and Basic.
+type MultiAuth []Authenticator
+
+func (m MultiAuth) Authenticate(h *RequestHeader, cred *Credentials) (string, bool) {
+	for _, a := range m {
+		if user, ok := a.Authenticate(h, cred); ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func (m MultiAuth) Challenges(cred *Credentials) (challenges []string) {
+	for _, a := range m {
+		challenges = append(challenges, a.Challenges(cred)...)
+	}
+	return
+}
+
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ProxyAuthenticate checks the Proxy-Authorization header of req with a.
+// If it fails, a "407 Proxy Authentication Required" response with the
+// challenges of a is written to bw and the request body is discarded, or
+// left unread if the client waits for "100 Continue", which ends the
+// connection; keepAlive reports whether the next request can be read from
+// the same connection. Proxy-Authorization is removed from req either way.
+func ProxyAuthenticate(a Authenticator, req *Request, bw *bufio.Writer) (user string, keepAlive, ok bool) {
+	cred, _ := req.Header.ProxyCredentials()
+	user, ok = a.Authenticate(req.Header, cred)
+	req.Header.Del(bProxyAuthorization)
+	if ok {
+		return user, true, true
+	}
+
+	keepAlive = skipRequest(req, maxAuthDiscard)
+	if writeShortResponse(bw, http.StatusProxyAuthRequired, keepAlive, bProxyAuthenticate, a.Challenges(cred)...) != nil {
+		keepAlive = false
+	}
+	return "", keepAlive, false
+}
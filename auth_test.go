@@ -0,0 +1,196 @@
+package http1
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_RequestHeader_ProxyCredentials(t *testing.T) {
+	h := &RequestHeader{}
+	_, e := h.ProxyCredentials()
+	assert.Equal(t, ErrNoCredentials, e)
+
+	h.Set(bProxyAuthorization, []byte("Basic dXNlcjpwYTpzcw=="))
+	c, e := h.ProxyCredentials()
+	if assert.Nil(t, e) {
+		assert.Equal(t, &Credentials{Scheme: "Basic", Username: "user", Password: "pa:ss"}, c)
+	}
+
+	h.Set(bProxyAuthorization, []byte(`digest username="Mufasa", realm="a \"b\"", nc=00000001 ,qop=auth, uri="/x?a=1,2"`))
+	c, e = h.ProxyCredentials()
+	if assert.Nil(t, e) {
+		assert.Equal(t, "Digest", c.Scheme)
+		assert.Equal(t, "Mufasa", c.Username)
+		assert.Equal(t, map[string]string{
+			"username": "Mufasa", "realm": `a "b"`, "nc": "00000001", "qop": "auth", "uri": "/x?a=1,2",
+		}, c.Params)
+	}
+
+	for _, s := range []string{"Basic !!", "Basic dXNlcg==", `Digest username="x`, "Digest =x"} {
+		h.Set(bProxyAuthorization, []byte(s))
+		_, e = h.ProxyCredentials()
+		assert.Equal(t, ErrBadCredentials, e, s)
+	}
+}
+
+// digestResponse 按 RFC 7616 计算客户端的 Proxy-Authorization
+func digestResponse(challenge map[string]string, method, uri, user, password, nc string) string {
+	newHash := md5.New
+	if challenge["algorithm"] == "SHA-256" {
+		newHash = sha256.New
+	}
+	hexHash := func(s string) string {
+		var h hash.Hash = newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	ha1 := hexHash(user + ":" + challenge["realm"] + ":" + password)
+	ha2 := hexHash(method + ":" + uri)
+	response := hexHash(ha1 + ":" + challenge["nonce"] + ":" + nc + ":cn:auth:" + ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, qop=auth, nc=%s, cnonce="cn", response="%s", opaque="%s"`,
+		user, challenge["realm"], challenge["nonce"], uri, challenge["algorithm"], nc, response, challenge["opaque"])
+}
+
+func Test_Proxy_DigestAuth(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+	host := origin.Listener.Addr().String()
+
+	auth := &DigestAuth{Realm: "proxy", Store: StaticCredentials{"u": "p"}, NonceTTL: time.Hour}
+	p := &Proxy{Auth: auth}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	uri := "http://" + host + "/a"
+	// body 被丢弃，连接可以继续使用
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nContent-Length: 4\r\n\r\nping", uri, host)
+	resp, e := http.ReadResponse(br, nil)
+	if !assert.Nil(t, e) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, 407, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Connection"))
+	challenges := resp.Header["Proxy-Authenticate"]
+	if !assert.Len(t, challenges, 2) {
+		return
+	}
+	params, _ := parseAuthParams([]byte(challenges[0][len("Digest "):]))
+	assert.Equal(t, "SHA-256", params["algorithm"])
+
+	for i, c := range []struct {
+		nc     string
+		status int
+	}{{"00000001", 200}, {"00000002", 200}, {"00000002", 407}} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n", uri, host, digestResponse(params, "GET", uri, "u", "p", c.nc))
+		resp, e = http.ReadResponse(br, nil)
+		if !assert.Nil(t, e) {
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, c.status, resp.StatusCode, "重复的 nc 被拒绝 %d", i)
+		if c.status == 200 {
+			assert.Equal(t, "GET "+host+" /a ", string(body))
+		}
+	}
+
+	// 密码错误
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n", uri, host, digestResponse(params, "GET", uri, "u", "x", "00000003"))
+	resp, e = http.ReadResponse(br, nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 407, resp.StatusCode)
+		assert.NotContains(t, resp.Header.Get("Proxy-Authenticate"), "stale")
+	}
+
+	// 只记录认证成功的 nonce
+	auth.mu.Lock()
+	assert.Len(t, auth.nonces, 1)
+	auth.mu.Unlock()
+
+	// 伪造的 nonce 被拒绝
+	forged := map[string]string{}
+	for k, v := range params {
+		forged[k] = v
+	}
+	forged["nonce"] = params["nonce"][:16] + strings.Repeat("0", len(params["nonce"])-16)
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n", uri, host, digestResponse(forged, "GET", uri, "u", "p", "00000001"))
+	resp, e = http.ReadResponse(br, nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 407, resp.StatusCode)
+	}
+
+	// 过期的 nonce 标记为 stale
+	params["nonce"] = auth.nonceAt(time.Now().Add(-2 * time.Hour))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n", uri, host, digestResponse(params, "GET", uri, "u", "p", "00000004"))
+	resp, e = http.ReadResponse(br, nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 407, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Proxy-Authenticate"), "stale=true")
+	}
+}
+
+func Test_Proxy_AuthExpectContinue(t *testing.T) {
+	p := &Proxy{Auth: &BasicAuth{Realm: "proxy", Store: StaticCredentials{"u": "p"}}}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 客户端等待 100 Continue，不发送body：直接回复 407 并关闭连接
+	fmt.Fprintf(conn, "POST http://a/ HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nExpect: 100-continue\r\n\r\n")
+	resp, e := http.ReadResponse(bufio.NewReader(conn), nil)
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.Equal(t, 407, resp.StatusCode)
+		assert.True(t, resp.Close)
+	}
+}
+
+func Test_Proxy_BasicAuth(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+
+	p := &Proxy{Auth: MultiAuth{
+		&DigestAuth{Realm: "proxy", Store: StaticCredentials{"u": "p"}},
+		&BasicAuth{Realm: "proxy", Store: StaticCredentials{"u": "p"}},
+	}}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	for _, c := range []struct {
+		user   *url.Userinfo
+		status int
+	}{{url.UserPassword("u", "p"), 200}, {url.UserPassword("u", "x"), 407}, {nil, 407}} {
+		u := &url.URL{Scheme: "http", Host: addr, User: c.user}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+		resp, e := client.Get(origin.URL + "/")
+		if assert.Nil(t, e) {
+			resp.Body.Close()
+			assert.Equal(t, c.status, resp.StatusCode)
+			if c.status == 407 {
+				assert.Len(t, resp.Header["Proxy-Authenticate"], 3)
+			}
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
 	"sync"
 )
 
@@ -149,3 +153,111 @@ func parseChunkHeaderLength(v []byte) (n uint64, err error) {
 	}
 	return
 }
+
+const maxChunkSize = 4096
+
+// chunkedEncoder 把长度未知的reader编码为chunked格式，和chunkedReader一样输出的是原始报文
+type chunkedEncoder struct {
+	r    io.Reader
+	err  error
+	buf  []byte // 待输出的已编码数据
+	off  int
+	data []byte
+}
+
+func newChunkedEncoder(r io.Reader) *chunkedEncoder {
+	return &chunkedEncoder{r: r}
+}
+
+func (ce *chunkedEncoder) Read(b []byte) (n int, err error) {
+	for {
+		if ce.off < len(ce.buf) {
+			n = copy(b, ce.buf[ce.off:])
+			ce.off += n
+			return n, nil
+		}
+		if ce.err != nil {
+			return 0, ce.err
+		}
+		ce.fill()
+	}
+}
+
+func (ce *chunkedEncoder) fill() {
+	if ce.data == nil {
+		ce.data = make([]byte, maxChunkSize)
+		ce.buf = make([]byte, 0, maxChunkSize+16)
+	}
+	ce.buf = ce.buf[:0]
+	ce.off = 0
+
+	var nr int
+	nr, ce.err = ce.r.Read(ce.data)
+	if nr > 0 {
+		ce.buf = strconv.AppendInt(ce.buf, int64(nr), 16)
+		ce.buf = append(ce.buf, CRLF...)
+		ce.buf = append(ce.buf, ce.data[:nr]...)
+		ce.buf = append(ce.buf, CRLF...)
+	}
+	if ce.err == io.EOF {
+		ce.buf = append(ce.buf, lastChunk...)
+	}
+}
+
+var lastChunk = []byte("0\r\n\r\n")
+
+// DecodedBody returns the body without the chunked transfer coding; Body
+// itself is read as it is on the wire. Reading it to EOF consumes the whole
+// message body, so the next request can be read from the connection.
+// Content-Encoding is not removed.
+func (m *Request) DecodedBody() io.Reader {
+	if m.Body == nil {
+		return http.NoBody
+	}
+	if _, ok := m.rawBody().(*chunkedReader); !ok {
+		return m.Body
+	}
+	if m.decoded == nil || m.decoded.body != m.Body {
+		m.decoded = newDechunkedReader(m.Body)
+	}
+	return m.decoded
+}
+
+// DecodedBody returns the body without the chunked transfer coding, like
+// Request.DecodedBody. The body of a response from Client.Do is already
+// decoded.
+func (m *Response) DecodedBody() io.Reader {
+	if m.Body == nil {
+		return http.NoBody
+	}
+	if _, ok := m.Body.(*clientBody); ok {
+		return m.Body
+	}
+	if _, ok := m.rawBody().(*chunkedReader); !ok {
+		return m.Body
+	}
+	if m.decoded == nil || m.decoded.body != m.Body {
+		m.decoded = newDechunkedReader(m.Body)
+	}
+	return m.decoded
+}
+
+func newDechunkedReader(body io.Reader) *dechunkedReader {
+	return &dechunkedReader{r: httputil.NewChunkedReader(body), body: body}
+}
+
+// dechunkedReader 在 httputil 的 chunkedReader 结束后读完 body 剩下的最后的 CRLF
+type dechunkedReader struct {
+	r    io.Reader
+	body io.Reader
+}
+
+func (d *dechunkedReader) Read(b []byte) (n int, err error) {
+	n, err = d.r.Read(b)
+	if err == io.EOF {
+		if _, derr := io.Copy(ioutil.Discard, d.body); derr != nil {
+			err = derr
+		}
+	}
+	return
+}
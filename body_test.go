@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"bufio"
+	"net/http"
 )
 
 func Test_parseChunkHeaderLength(t *testing.T) {
@@ -108,3 +109,34 @@ func Test_ChunkedReader_Read_DifferentSize(t *testing.T) {
 		cr.Reset(bufio.NewReader(bytes.NewReader(blob)))
 	}
 }
+
+func Test_Request_DecodedBody(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\nGET /next HTTP/1.1\r\n\r\n"))
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	assert.Nil(t, req.Read(br))
+
+	b, e := ioutil.ReadAll(req.DecodedBody())
+	assert.Nil(t, e)
+	assert.Equal(t, "abcde", string(b))
+
+	// 整个body都被读取，可以读下一个请求
+	assert.Nil(t, req.Read(br))
+	assert.Equal(t, "/next", req.RequestURI())
+	assert.Equal(t, http.NoBody, req.DecodedBody())
+}
+
+func Test_Response_DecodedBody(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"))
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	assert.Nil(t, resp.Read(br, nil))
+
+	b, e := ioutil.ReadAll(resp.DecodedBody())
+	assert.Nil(t, e)
+	assert.Equal(t, "abc", string(b))
+
+	assert.Nil(t, resp.Read(br, nil))
+	assert.Equal(t, 204, resp.Header.StatusCode)
+	assert.Equal(t, http.NoBody, resp.DecodedBody())
+}
@@ -0,0 +1,143 @@
+package http1
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client sends requests over keep-alive connections borrowed from a
+// ConnPool. Only plain HTTP is supported.
+type Client struct {
+	// Pool holds the connections. If nil, the client uses a pool of its
+	// own built from Dialer and DialTimeout.
+	Pool *ConnPool
+
+	Dialer      Dialer // nil means net.Dialer with DialTimeout
+	DialTimeout time.Duration
+
+	// Timeout limits writing the request and reading the response header.
+	// 0 means no timeout.
+	Timeout time.Duration
+
+	poolOnce sync.Once
+	ownPool  *ConnPool
+}
+
+func (c *Client) pool() *ConnPool {
+	if c.Pool != nil {
+		return c.Pool
+	}
+	c.poolOnce.Do(func() {
+		c.ownPool = &ConnPool{Dialer: c.Dialer, DialTimeout: c.DialTimeout}
+	})
+	return c.ownPool
+}
+
+// Do sends req to the server given by its absolute-form target or Host
+// header, rewriting the target to origin-form, and reads the response
+// header. 1xx responses are skipped.
+//
+// Unlike ReadResponse, the Body of the returned response is decoded, and
+// the connection goes back to the pool once it has been read to EOF. Call
+// Response.Close if the body is not read completely, then ReleaseResponse.
+func (c *Client) Do(req *Request) (resp *Response, err error) {
+	addr, err := requestTarget(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := c.pool()
+	resp = AcquireResponse()
+	for {
+		var conn *PoolConn
+		if conn, err = pool.Get(addr); err != nil {
+			break
+		}
+		if c.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(c.Timeout))
+		}
+		if err = conn.roundTrip(req, resp, nil); err == nil {
+			conn.SetDeadline(time.Time{})
+			setClientBody(resp, pool, conn)
+			return resp, nil
+		}
+
+		conn.Close()
+		// 复用的连接可能已被服务端关闭，没有body的请求可以在新连接上重试
+		if !conn.Reused() || req.Body != nil && req.Body != http.NoBody {
+			break
+		}
+	}
+	ReleaseResponse(resp)
+	return nil, err
+}
+
+// Close closes the body of a response returned by Client.Do. A body that
+// has not been read to EOF closes its connection instead of reusing it.
+func (m *Response) Close() error {
+	if c, ok := m.Body.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// setClientBody 把响应的body换成解码后的 clientBody，没有body时直接归还连接
+func setClientBody(resp *Response, pool *ConnPool, conn *PoolConn) {
+	keepAlive := resp.KeepAlive()
+	if resp.Body == http.NoBody {
+		if keepAlive {
+			pool.Put(conn)
+		} else {
+			conn.Close()
+		}
+		return
+	}
+
+	resp.Body = &clientBody{raw: resp.Body, r: resp.DecodedBody(), pool: pool, conn: conn, keepAlive: keepAlive}
+}
+
+// clientBody 读到EOF时把连接还给连接池，提前 Close 时关闭连接
+type clientBody struct {
+	r         io.Reader // 解码后的body
+	raw       io.Reader // readBody 得到的原始body
+	pool      *ConnPool
+	conn      *PoolConn
+	keepAlive bool
+	closed    bool
+}
+
+func (b *clientBody) Read(p []byte) (n int, err error) {
+	if b.closed {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	if b.conn == nil {
+		return 0, io.EOF
+	}
+	n, err = b.r.Read(p)
+	if err == io.EOF {
+		b.release(true)
+	} else if err != nil {
+		b.release(false)
+	}
+	return
+}
+
+func (b *clientBody) Close() error {
+	b.release(false)
+	b.closed = true
+	return nil
+}
+
+func (b *clientBody) release(eof bool) {
+	if b.conn == nil {
+		return
+	}
+	if eof && b.keepAlive {
+		b.pool.Put(b.conn)
+	} else {
+		b.conn.Close()
+	}
+	b.conn = nil
+}
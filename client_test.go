@@ -0,0 +1,68 @@
+package http1
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Client_Do(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+	host := origin.Listener.Addr().String()
+
+	pool := &ConnPool{}
+	defer pool.Close()
+	c := &Client{Pool: pool}
+
+	for i := 0; i < 3; i++ {
+		resp, e := c.Do(NewRequest("GET", "http://"+host+"/a", nil))
+		assert.Nil(t, e)
+		assert.Equal(t, 200, resp.Header.StatusCode)
+		body, e := ioutil.ReadAll(resp.Body)
+		assert.Nil(t, e)
+		assert.Equal(t, "GET "+host+" /a ", string(body))
+		ReleaseResponse(resp)
+	}
+	assert.Equal(t, PoolStats{Dials: 1, Hits: 2, Idle: 1}, pool.Stats())
+
+	// chunked 的响应被解码，DecodedBody 就是 Body，读完之后连接仍然可以复用
+	resp, e := c.Do(NewRequest("GET", "http://"+host+"/chunked", nil))
+	assert.Nil(t, e)
+	assert.True(t, resp.Header.GetChunkedEncoding())
+	assert.Equal(t, resp.Body, resp.DecodedBody())
+	body, _ := ioutil.ReadAll(resp.DecodedBody())
+	assert.Equal(t, "ab", string(body))
+	ReleaseResponse(resp)
+	assert.Equal(t, 1, pool.Stats().Idle)
+
+	req := NewRequest("POST", "/echo", strings.NewReader("ping"))
+	req.Header.Add([]byte("Host"), []byte(host))
+	req.Header.Add([]byte("Content-Length"), []byte("4"))
+	resp, e = c.Do(req)
+	assert.Nil(t, e)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "POST "+host+" /echo ping", string(body))
+	ReleaseResponse(resp)
+
+	// 没读完就 Close，连接不再复用
+	resp, e = c.Do(NewRequest("GET", "http://"+host+"/a", nil))
+	assert.Nil(t, e)
+	assert.Nil(t, resp.Close())
+	_, e = resp.Body.Read(make([]byte, 1))
+	assert.Equal(t, http.ErrBodyReadAfterClose, e)
+	ReleaseResponse(resp)
+	assert.Equal(t, 0, pool.Stats().Idle)
+
+	// HEAD 的响应没有body，连接立即归还
+	resp, e = c.Do(NewRequest("HEAD", "http://"+host+"/a", nil))
+	assert.Nil(t, e)
+	assert.Equal(t, http.NoBody, resp.Body)
+	ReleaseResponse(resp)
+	assert.Equal(t, 1, pool.Stats().Idle)
+
+	_, e = c.Do(NewRequest("GET", "https://"+host+"/", nil))
+	assert.Equal(t, errUnsupportedScheme, e)
+}
@@ -0,0 +1,306 @@
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCookie = errors.New("http1: invalid Set-Cookie")
+
+var (
+	bCookie    = []byte("Cookie")
+	bSetCookie = []byte("Set-Cookie")
+)
+
+// Set-Cookie 中 Expires 常见的几种格式（RFC 6265 5.1.1 比较宽松，这里只接受常见的）
+var cookieTimeFormats = []string{
+	http.TimeFormat,
+	"Mon, 02-Jan-2006 15:04:05 MST",
+	time.RFC850,
+	time.ANSIC,
+}
+
+// VisitCookies calls f with the name and value of each cookie sent in the
+// Cookie headers, until f returns false. Quotes around values are removed.
+// name and value point into the header and are valid until it changes.
+func (h *RequestHeader) VisitCookies(f func(name, value []byte) bool) {
+	h.VisitFor(bCookie, func(i int, v []byte) bool {
+		for len(v) > 0 {
+			var pair []byte
+			if j := bytes.IndexByte(v, ';'); j == -1 {
+				pair, v = v, nil
+			} else {
+				pair, v = v[:j], v[j+1:]
+			}
+			if pair = bytes.TrimSpace(pair); len(pair) == 0 {
+				continue
+			}
+			// 没有 '=' 时浏览器把它当作名字为空的值（RFC 6265bis）
+			var name, value []byte
+			if j := bytes.IndexByte(pair, '='); j == -1 {
+				value = pair
+			} else {
+				name, value = bytes.TrimSpace(pair[:j]), bytes.TrimSpace(pair[j+1:])
+			}
+			if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			if !f(name, value) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Cookie returns the value of the first cookie named name, or nil.
+func (h *RequestHeader) Cookie(name []byte) (value []byte) {
+	h.VisitCookies(func(n, v []byte) bool {
+		if bytes.Equal(n, name) {
+			value = v
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// Cookie is a cookie set by a response (RFC 6265).
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time // 零值表示没有 Expires
+	// MaxAge 为0表示没有 Max-Age，小于0时写为 Max-Age=0（立即删除）
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    http.SameSite // SameSiteDefaultMode 时不写
+	Partitioned bool          // CHIPS，浏览器要求同时设置 Secure
+
+	// Unparsed are the attributes ParseSetCookie did not recognize, written
+	// back as they are.
+	Unparsed []string
+}
+
+// AppendTo appends the value of a Set-Cookie header for c to dst. Bytes
+// not allowed in a cookie are dropped, and values with spaces or commas
+// are quoted.
+func (c *Cookie) AppendTo(dst []byte) []byte {
+	dst = appendCookieAttr(dst, c.Name, '=')
+	dst = append(dst, '=')
+	if strings.ContainsAny(c.Value, " ,") {
+		dst = append(dst, '"')
+		dst = appendCookieValue(dst, c.Value)
+		dst = append(dst, '"')
+	} else {
+		dst = appendCookieValue(dst, c.Value)
+	}
+
+	if c.Path != "" {
+		dst = append(dst, "; Path="...)
+		dst = appendCookieAttr(dst, c.Path, 0)
+	}
+	if c.Domain != "" {
+		dst = append(dst, "; Domain="...)
+		dst = appendCookieAttr(dst, strings.TrimPrefix(c.Domain, "."), 0)
+	}
+	if !c.Expires.IsZero() {
+		dst = append(dst, "; Expires="...)
+		dst = c.Expires.UTC().AppendFormat(dst, http.TimeFormat)
+	}
+	if c.MaxAge > 0 {
+		dst = append(dst, "; Max-Age="...)
+		dst = strconv.AppendInt(dst, int64(c.MaxAge), 10)
+	} else if c.MaxAge < 0 {
+		dst = append(dst, "; Max-Age=0"...)
+	}
+	if c.Secure {
+		dst = append(dst, "; Secure"...)
+	}
+	if c.HttpOnly {
+		dst = append(dst, "; HttpOnly"...)
+	}
+	switch c.SameSite {
+	case http.SameSiteLaxMode:
+		dst = append(dst, "; SameSite=Lax"...)
+	case http.SameSiteStrictMode:
+		dst = append(dst, "; SameSite=Strict"...)
+	case http.SameSiteNoneMode:
+		dst = append(dst, "; SameSite=None"...)
+	}
+	if c.Partitioned {
+		dst = append(dst, "; Partitioned"...)
+	}
+	for _, a := range c.Unparsed {
+		dst = append(dst, "; "...)
+		dst = appendCookieAttr(dst, a, 0)
+	}
+	return dst
+}
+
+func (c *Cookie) String() string {
+	return string(c.AppendTo(nil))
+}
+
+// appendCookieValue 只保留 cookie-octet，以及会被引号括起来的空格和逗号
+func appendCookieValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; 0x20 <= b && b < 0x7f && b != '"' && b != ';' && b != '\\' {
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// appendCookieAttr 去掉控制字符、';' 以及 drop 指定的字符
+func appendCookieAttr(dst []byte, s string, drop byte) []byte {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; 0x20 <= b && b < 0x7f && b != ';' && b != drop {
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// ParseSetCookie parses the value of a Set-Cookie header. Attributes with
+// invalid values are kept in Unparsed like unknown ones.
+func ParseSetCookie(value []byte) (*Cookie, error) {
+	var pair []byte
+	if i := bytes.IndexByte(value, ';'); i == -1 {
+		pair, value = value, nil
+	} else {
+		pair, value = value[:i], value[i+1:]
+	}
+	i := bytes.IndexByte(pair, '=')
+	if i == -1 {
+		return nil, ErrInvalidCookie
+	}
+	name, v := bytes.TrimSpace(pair[:i]), bytes.TrimSpace(pair[i+1:])
+	if len(name) == 0 || bytes.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) != -1 {
+		return nil, ErrInvalidCookie
+	}
+	if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	c := &Cookie{Name: string(name), Value: string(v)}
+
+	for len(value) > 0 {
+		var attr []byte
+		if j := bytes.IndexByte(value, ';'); j == -1 {
+			attr, value = value, nil
+		} else {
+			attr, value = value[:j], value[j+1:]
+		}
+		if attr = bytes.TrimSpace(attr); len(attr) == 0 {
+			continue
+		}
+		key, val := attr, []byte(nil)
+		if j := bytes.IndexByte(attr, '='); j != -1 {
+			key, val = bytes.TrimSpace(attr[:j]), bytes.TrimSpace(attr[j+1:])
+		}
+
+		ok := true
+		switch strings.ToLower(string(key)) {
+		case "path":
+			c.Path = string(val)
+		case "domain":
+			c.Domain = strings.TrimPrefix(string(val), ".")
+		case "expires":
+			ok = false
+			for _, layout := range cookieTimeFormats {
+				if t, err := time.Parse(layout, string(val)); err == nil {
+					c.Expires, ok = t.UTC(), true
+					break
+				}
+			}
+		case "max-age":
+			var n int
+			n, ok = parseMaxAge(val)
+			if ok {
+				c.MaxAge = n
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(string(val)) {
+			case "lax":
+				c.SameSite = http.SameSiteLaxMode
+			case "strict":
+				c.SameSite = http.SameSiteStrictMode
+			case "none":
+				c.SameSite = http.SameSiteNoneMode
+			default:
+				ok = false
+			}
+		case "partitioned":
+			c.Partitioned = true
+		default:
+			ok = false
+		}
+		if !ok {
+			c.Unparsed = append(c.Unparsed, string(attr))
+		}
+	}
+	return c, nil
+}
+
+// parseMaxAge 返回 Cookie.MaxAge 的值，0和负数都表示立即删除
+func parseMaxAge(b []byte) (int, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	n, end, err := parseUintBuf(b)
+	if err != nil || end != len(b) {
+		return 0, false
+	}
+	if neg || n == 0 {
+		return -1, true
+	}
+	return n, true
+}
+
+func isTokenChar(r rune) bool {
+	return r < 0x7f && r > 0x20 && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+}
+
+// SetCookie adds a Set-Cookie header for c.
+func (h *ResponseHeader) SetCookie(c *Cookie) {
+	h.Add(bSetCookie, c.AppendTo(nil))
+}
+
+// SetCookies parses the Set-Cookie headers, skipping invalid ones.
+func (h *ResponseHeader) SetCookies() (cookies []*Cookie) {
+	h.VisitFor(bSetCookie, func(i int, value []byte) bool {
+		if c, err := ParseSetCookie(value); err == nil {
+			cookies = append(cookies, c)
+		}
+		return true
+	})
+	return
+}
+
+// RewriteSetCookies calls f with each valid Set-Cookie header parsed. When f
+// returns true the header is replaced in place by the modified cookie, e.g.
+// for a proxy to change the Domain of cookies set by the upstream.
+func (h *ResponseHeader) RewriteSetCookies(f func(c *Cookie) bool) {
+	h.VisitFor(bSetCookie, func(i int, value []byte) bool {
+		c, err := ParseSetCookie(value)
+		if err != nil || !f(c) {
+			return true
+		}
+		line := append(bSetCookie[:len(bSetCookie):len(bSetCookie)], ':', ' ')
+		h.headers[i] = c.AppendTo(line)
+		h.cacheValid = false
+		return true
+	})
+}
@@ -0,0 +1,98 @@
+package http1
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_RequestHeader_VisitCookies(t *testing.T) {
+	h, e := readRequestHeader([]string{
+		"GET / HTTP/1.1",
+		`Cookie: a=1; b="x y" ;;c=`,
+		"Cookie: flag; a=2",
+		"\r\n",
+	})
+	if !assert.Nil(t, e) {
+		return
+	}
+
+	var pairs []string
+	h.VisitCookies(func(name, value []byte) bool {
+		pairs = append(pairs, string(name)+"|"+string(value))
+		return true
+	})
+	assert.Equal(t, []string{"a|1", "b|x y", "c|", "|flag", "a|2"}, pairs)
+	assert.Equal(t, "1", string(h.Cookie([]byte("a"))))
+	assert.Nil(t, h.Cookie([]byte("d")))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		h.Cookie([]byte("c"))
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func Test_Cookie_AppendTo(t *testing.T) {
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a b\r\n;c",
+		Path:        "/;x",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600)),
+		MaxAge:      60,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    http.SameSiteNoneMode,
+		Partitioned: true,
+	}
+	assert.Equal(t, `id="a bc"; Path=/x; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=60; Secure; HttpOnly; SameSite=None; Partitioned`, c.String())
+	assert.Equal(t, "x=; Max-Age=0", (&Cookie{Name: "x", MaxAge: -1}).String())
+
+	h := &ResponseHeader{}
+	h.SetCookie(&Cookie{Name: "a", Value: "1"})
+	h.SetCookie(&Cookie{Name: "b", Value: "2"})
+	assert.Equal(t, "a=1", string(h.Get(bSetCookie)))
+	assert.Len(t, h.SetCookies(), 2)
+}
+
+func Test_ParseSetCookie(t *testing.T) {
+	c, e := ParseSetCookie([]byte(`sid="abc"; path=/app; DOMAIN=.Example.com; expires=Wed, 02-Jan-2030 02:04:05 GMT; Max-Age=0; secure; HttpOnly; SameSite=lax; Partitioned; Priority=High; max-age=1x`))
+	if assert.Nil(t, e) {
+		assert.Equal(t, &Cookie{
+			Name:        "sid",
+			Value:       "abc",
+			Path:        "/app",
+			Domain:      "Example.com",
+			Expires:     time.Date(2030, 1, 2, 2, 4, 5, 0, time.UTC),
+			MaxAge:      -1,
+			Secure:      true,
+			HttpOnly:    true,
+			SameSite:    http.SameSiteLaxMode,
+			Partitioned: true,
+			Unparsed:    []string{"Priority=High", "max-age=1x"},
+		}, c)
+	}
+
+	for _, s := range []string{"", "novalue", "=x", "a b=1", "a;b=1"} {
+		_, e = ParseSetCookie([]byte(s))
+		assert.Equal(t, ErrInvalidCookie, e, s)
+	}
+}
+
+func Test_ResponseHeader_RewriteSetCookies(t *testing.T) {
+	h := &ResponseHeader{Proto: []byte("HTTP/1.1"), StatusCode: 200}
+	h.Add(bSetCookie, []byte("a=1; Domain=internal.local; Path=/"))
+	h.Add([]byte("X-A"), []byte("1"))
+	h.Add(bSetCookie, []byte("b=2; Domain=other.com"))
+	h.Add(bSetCookie, []byte("invalid"))
+
+	h.RewriteSetCookies(func(c *Cookie) bool {
+		if c.Domain != "internal.local" {
+			return false
+		}
+		c.Domain = "example.com"
+		return true
+	})
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Path=/; Domain=example.com\r\nX-A: 1\r\nSet-Cookie: b=2; Domain=other.com\r\nSet-Cookie: invalid\r\n\r\n", string(h.Bytes()))
+}
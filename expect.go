@@ -0,0 +1,84 @@
+package http1
+
+import (
+	"bufio"
+	"io"
+)
+
+var (
+	bExpect      = []byte("Expect")
+	b100Continue = []byte("100-continue")
+
+	continueResponse = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+)
+
+// HasExpect reports whether the request carries an Expect header at all.
+func (m *Request) HasExpect() bool {
+	return len(m.Header.Get(bExpect)) > 0
+}
+
+// ExpectContinue reports whether the client waits for "100 Continue" before
+// sending the body. Expect is ignored for HTTP/1.0 requests (RFC 7231 5.1.1).
+func (m *Request) ExpectContinue() bool {
+	return m.Header.ProtoAtLeast(1, 1) && m.Header.HasToken(bExpect, b100Continue)
+}
+
+// EnableAutoContinue makes the first Body.Read write "100 Continue" to w,
+// unless a final response has been started before that.
+func (m *Request) EnableAutoContinue(w io.Writer) {
+	if !m.ExpectContinue() || m.Body == nil {
+		return
+	}
+	if _, ok := m.Body.(*continueReader); ok {
+		return
+	}
+	m.Body = &continueReader{r: m.Body, w: w}
+}
+
+// ContinueSent reports whether "100 Continue" has been written for m.
+// It is false for requests without auto continue enabled.
+func (m *Request) ContinueSent() bool {
+	cr, ok := m.Body.(*continueReader)
+	return ok && cr.sent
+}
+
+// WriteContinue writes the interim "100 Continue" response to w.
+func WriteContinue(w io.Writer) (err error) {
+	if _, err = w.Write(continueResponse); err == nil {
+		if bw, ok := w.(*bufio.Writer); ok {
+			err = bw.Flush()
+		}
+	}
+	return
+}
+
+type continueReader struct {
+	r    io.Reader
+	w    io.Writer
+	sent bool
+	skip bool // 已经开始写最终响应，不能再发送 100 Continue
+	err  error
+}
+
+func (cr *continueReader) Read(b []byte) (int, error) {
+	if !cr.sent && !cr.skip {
+		cr.sent = true
+		cr.err = WriteContinue(cr.w)
+	}
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	return cr.r.Read(b)
+}
+
+// rawBody 返回去掉 continueReader 和 replayReader 之后的body，用于判断body的类型
+func (m *Request) rawBody() io.Reader {
+	r := m.Body
+	if cr, ok := r.(*continueReader); ok {
+		r = cr.r
+	}
+	if rr, ok := r.(*replayReader); ok {
+		r = rr.r
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Request_ExpectContinue(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"
+	req, e := ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	assert.Nil(t, e)
+	assert.True(t, req.HasExpect())
+	assert.True(t, req.ExpectContinue())
+
+	w := bytes.NewBuffer(nil)
+	req.EnableAutoContinue(w)
+	assert.False(t, req.ContinueSent())
+	assert.Equal(t, "", w.String())
+
+	body, e := ioutil.ReadAll(req.Body)
+	assert.Nil(t, e)
+	assert.Equal(t, "hello", string(body))
+	assert.True(t, req.ContinueSent())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", w.String())
+	ReleaseRequest(req)
+
+	// HTTP/1.0 忽略 Expect
+	raw = "POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"
+	req, e = ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	assert.Nil(t, e)
+	assert.False(t, req.ExpectContinue())
+	ReleaseRequest(req)
+}
+
+func expectRequest(t *testing.T, addr, expect string) (conn net.Conn, br *bufio.Reader) {
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: a\r\nExpect: " + expect + "\r\nContent-Length: 5\r\n\r\n"))
+	return conn, bufio.NewReader(conn)
+}
+
+func Test_Server_AutoContinue(t *testing.T) {
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		io.Copy(w, req.Body)
+	})
+	defer s.Close()
+
+	conn, br := expectRequest(t, addr, "100-continue")
+	defer conn.Close()
+
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusContinue, resp.StatusCode)
+
+	conn.Write([]byte("hello"))
+	resp, e = http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "hello", string(body))
+}
+
+func Test_Server_CheckContinue(t *testing.T) {
+	called := false
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		called = true
+	})
+	s.CheckContinue = func(req *Request) int {
+		return http.StatusRequestEntityTooLarge
+	}
+	defer s.Close()
+
+	conn, br := expectRequest(t, addr, "100-continue")
+	defer conn.Close()
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close)
+	assert.False(t, called)
+
+	// 未知的 Expect 返回 417
+	conn2, br2 := expectRequest(t, addr, "something-else")
+	defer conn2.Close()
+	resp, e = http.ReadResponse(br2, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+}
+
+func Test_Server_Continue_StdClient(t *testing.T) {
+	s, addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+	req, _ := http.NewRequest("POST", "http://"+addr+"/", strings.NewReader("payload"))
+	req.Header.Set("Expect", "100-continue")
+
+	begin := time.Now()
+	resp, e := client.Do(req)
+	assert.Nil(t, e)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "payload", string(body))
+	// 如果服务端没有发送 100 Continue，客户端会等到超时
+	assert.True(t, time.Since(begin) < 5*time.Second)
+}
@@ -0,0 +1,289 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHijacked        = errors.New("http1: connection has been hijacked")
+	ErrBodyNotAllowed  = http.ErrBodyNotAllowed
+	ErrAlreadyHijacked = errors.New("http1: Hijack called twice")
+)
+
+var (
+	bDate        = []byte("Date")
+	bContentType = []byte("Content-Type")
+)
+
+// Handler 是不经过 net/http 类型的原生接口，可以直接操作 RequestHeader
+type Handler interface {
+	ServeHTTP1(w *ResponseWriter, req *Request)
+}
+
+type HandlerFunc func(w *ResponseWriter, req *Request)
+
+func (f HandlerFunc) ServeHTTP1(w *ResponseWriter, req *Request) {
+	f(w, req)
+}
+
+// ResponseWriter buffers the response header and small bodies. When the
+// handler returns before the buffer fills up the response is sent with
+// Content-Length, otherwise chunked encoding is used (or, for HTTP/1.0
+// clients, the connection is closed after the body).
+type ResponseWriter struct {
+	Header *ResponseHeader
+
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+	req  *Request
+
+	headerWritten bool // 状态行和响应头已写入bw
+	chunked       bool
+	closeAfter    bool
+	hijacked      bool
+	sniff         bool // 没有 Content-Type 时根据body探测，和 net/http 行为一致
+	buf           []byte
+	scratch       []byte
+	err           error
+}
+
+var responseWriterPool sync.Pool
+
+func acquireResponseWriter(conn net.Conn, br *bufio.Reader, bw *bufio.Writer, req *Request) (w *ResponseWriter) {
+	if x := responseWriterPool.Get(); x == nil {
+		w = &ResponseWriter{Header: NewResponseHeader()}
+	} else {
+		w = x.(*ResponseWriter)
+	}
+	w.conn = conn
+	w.br = br
+	w.bw = bw
+	w.req = req
+	return
+}
+
+func releaseResponseWriter(w *ResponseWriter) {
+	w.Header.reset()
+	w.conn = nil
+	w.br = nil
+	w.bw = nil
+	w.req = nil
+	w.headerWritten = false
+	w.chunked = false
+	w.closeAfter = false
+	w.hijacked = false
+	w.sniff = false
+	w.buf = w.buf[:0]
+	w.err = nil
+	responseWriterPool.Put(w)
+}
+
+// WriteHeader sets the status code. It has no effect once the header has been sent.
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if !w.headerWritten {
+		w.Header.StatusCode = statusCode
+	}
+}
+
+func (w *ResponseWriter) Write(p []byte) (n int, err error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.Header.StatusCode == 0 {
+		w.Header.StatusCode = http.StatusOK
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !bodyAllowedForStatus(w.Header.StatusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+
+	if !w.headerWritten {
+		if len(w.buf)+len(p) <= responseBufferSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		w.writeHeader(-1)
+		w.writeBody(w.buf)
+		w.buf = w.buf[:0]
+	}
+
+	w.writeBody(p)
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func (w *ResponseWriter) WriteString(s string) (n int, err error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the header and any buffered body to the client. After Flush
+// the response can no longer use Content-Length.
+func (w *ResponseWriter) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.headerWritten {
+		w.writeHeader(-1)
+		w.writeBody(w.buf)
+		w.buf = w.buf[:0]
+	}
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
+
+// Hijack lets the caller take over the connection. Anything already
+// written through w, including a status set by WriteHeader, is sent first
+// as finish would; the returned ReadWriter holds the bytes the client sent
+// after the current request.
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrAlreadyHijacked
+	}
+	if !w.headerWritten && (w.Header.StatusCode != 0 || len(w.buf) > 0) {
+		w.writeHeader(len(w.buf))
+		w.writeBody(w.buf)
+		w.buf = w.buf[:0]
+	}
+	if w.headerWritten && w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	if w.err != nil {
+		return nil, nil, w.err
+	}
+	w.hijacked = true
+	// 接管之后不再受 Server 读取body的超时限制
+	w.conn.SetReadDeadline(time.Time{})
+	return w.conn, bufio.NewReadWriter(w.br, w.bw), nil
+}
+
+func (w *ResponseWriter) finish() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.headerWritten {
+		w.writeHeader(len(w.buf))
+		w.writeBody(w.buf)
+	}
+	if w.chunked && w.err == nil {
+		_, w.err = w.bw.Write(lastChunk)
+	}
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
+
+// writeHeader 写状态行和响应头，contentLength 为-1表示长度未知
+func (w *ResponseWriter) writeHeader(contentLength int) {
+	w.headerWritten = true
+	h := w.Header
+	if h.StatusCode == 0 {
+		h.StatusCode = http.StatusOK
+	}
+	isHead := bytes.Equal(w.req.Header.Method, bHEAD)
+
+	// 最终响应之后不能再发送 100 Continue
+	if cr, ok := w.req.Body.(*continueReader); ok && !cr.sent {
+		cr.skip = true
+	}
+
+	if bodyAllowedForStatus(h.StatusCode) {
+		if h.GetContentLength() >= 0 {
+			contentLength = -2 // handler 自己声明了长度
+		}
+		switch {
+		case contentLength >= 0:
+			if contentLength > 0 || !isHead {
+				w.scratch = strconv.AppendInt(w.scratch[:0], int64(contentLength), 10)
+				h.Set(bContentLength, w.scratch)
+			}
+		case contentLength == -1 && isHead:
+		case contentLength == -1 && w.req.Header.ProtoAtLeast(1, 1):
+			w.chunked = true
+			h.Set(bTransferEncoding, bChunked)
+		case contentLength == -1:
+			// HTTP/1.0 只能靠关闭连接结束body
+			w.closeAfter = true
+		}
+		if w.sniff && len(w.buf) > 0 && len(h.Get(bContentType)) == 0 {
+			h.Add(bContentType, []byte(http.DetectContentType(w.buf)))
+		}
+	} else {
+		h.Del(bContentLength)
+		h.Del(bTransferEncoding)
+	}
+
+	if len(h.Get(bDate)) == 0 {
+		h.Add(bDate, currentDate())
+	}
+
+	if w.closeAfter {
+		h.Set(bConnection, bClose)
+	} else if h.HasToken(bConnection, bClose) {
+		w.closeAfter = true
+	} else if !w.req.Header.ProtoAtLeast(1, 1) {
+		// HTTP/1.0 客户端需要明确的 keep-alive
+		h.Set(bConnection, bKeepAlive)
+	}
+
+	_, w.err = h.WriteTo(w.bw)
+}
+
+func (w *ResponseWriter) writeBody(p []byte) {
+	if w.err != nil || len(p) == 0 || bytes.Equal(w.req.Header.Method, bHEAD) {
+		return
+	}
+	if w.chunked {
+		w.scratch = strconv.AppendInt(w.scratch[:0], int64(len(p)), 16)
+		w.scratch = append(w.scratch, CRLF...)
+		w.bw.Write(w.scratch)
+	}
+	if _, w.err = w.bw.Write(p); w.err == nil && w.chunked {
+		_, w.err = w.bw.Write(CRLF)
+	}
+}
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+type dateValue struct {
+	sec int64
+	b   []byte
+}
+
+var dateCache atomic.Value
+
+// currentDate 返回当前时间的 HTTP-date，每秒只格式化一次
+func currentDate() []byte {
+	now := time.Now()
+	if v, ok := dateCache.Load().(*dateValue); ok && v.sec == now.Unix() {
+		return v.b
+	}
+	v := &dateValue{sec: now.Unix(), b: []byte(now.UTC().Format(http.TimeFormat))}
+	dateCache.Store(v)
+	return v.b
+}
@@ -0,0 +1,182 @@
+package http1
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func startNativeServer(t testing.TB, handler HandlerFunc) (s *Server, addr string) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s = &Server{NativeHandler: handler}
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func Test_ResponseWriter(t *testing.T) {
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		switch req.RequestURI() {
+		case "/small":
+			w.Header.Set([]byte("X-Method"), req.Header.Method)
+			w.WriteString("hello")
+		case "/big":
+			w.WriteString(strings.Repeat("x", responseBufferSize))
+			w.WriteString("y")
+		case "/flush":
+			w.WriteString("first")
+			w.Flush()
+			w.WriteString("second")
+		case "/declared":
+			w.Header.Set(bContentLength, []byte("3"))
+			w.Flush()
+			w.WriteString("abc")
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+			_, e := w.WriteString("body")
+			assert.Equal(t, ErrBodyNotAllowed, e)
+		case "/close":
+			w.Header.Set(bConnection, bClose)
+			w.WriteString("bye")
+		}
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	get := func(method, uri string) (*http.Response, string) {
+		conn.Write([]byte(method + " " + uri + " HTTP/1.1\r\nHost: a\r\n\r\n"))
+		resp, e := http.ReadResponse(br, &http.Request{Method: method})
+		assert.Nil(t, e, uri)
+		body, e := ioutil.ReadAll(resp.Body)
+		assert.Nil(t, e, uri)
+		return resp, string(body)
+	}
+
+	resp, body := get("GET", "/small")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "GET", resp.Header.Get("X-Method"))
+	assert.NotEmpty(t, resp.Header.Get("Date"))
+
+	resp, body = get("HEAD", "/small")
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "", body)
+
+	resp, body = get("GET", "/big")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, responseBufferSize+1, len(body))
+
+	resp, body = get("GET", "/flush")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "firstsecond", body)
+
+	resp, body = get("GET", "/declared")
+	assert.Nil(t, resp.TransferEncoding)
+	assert.Equal(t, "abc", body)
+
+	resp, body = get("GET", "/nocontent")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "", body)
+
+	resp, body = get("GET", "/close")
+	assert.True(t, resp.Close)
+	assert.Equal(t, "bye", body)
+	_, e = br.ReadByte()
+	assert.Equal(t, io.EOF, e)
+}
+
+func Test_ResponseWriter_HTTP10(t *testing.T) {
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		w.WriteString(strings.Repeat("x", responseBufferSize+1))
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	b, e := ioutil.ReadAll(conn)
+	assert.Nil(t, e)
+	resp, e := http.ReadResponse(bufio.NewReader(strings.NewReader(string(b))), nil)
+	assert.Nil(t, e)
+	assert.Nil(t, resp.TransferEncoding)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, responseBufferSize+1, len(body))
+}
+
+func Test_ResponseWriter_Hijack(t *testing.T) {
+	done := make(chan struct{})
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		conn, rw, e := w.Hijack()
+		assert.Nil(t, e)
+
+		_, _, e = w.Hijack()
+		assert.Equal(t, ErrAlreadyHijacked, e)
+		_, e = w.WriteString("x")
+		assert.Equal(t, ErrHijacked, e)
+
+		go func() {
+			defer close(done)
+			defer conn.Close()
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo: " + line)
+			rw.Flush()
+		}()
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\nping\n"))
+	b, e := ioutil.ReadAll(conn)
+	assert.Nil(t, e)
+	assert.Equal(t, "echo: ping\n", string(b))
+	<-done
+}
+
+func Test_ResponseWriter_HijackAfterWrite(t *testing.T) {
+	// WriteHeader 和还在缓冲里的body在接管连接之前发出
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.WriteString("hi")
+		conn, rw, e := w.Hijack()
+		if !assert.Nil(t, e) {
+			return
+		}
+		rw.WriteString("raw")
+		rw.Flush()
+		conn.Close()
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, nil)
+	if !assert.Nil(t, e) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "hi", string(body))
+	rest, _ := ioutil.ReadAll(br)
+	assert.Equal(t, "raw", string(rest))
+}
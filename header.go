@@ -5,11 +5,15 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"sync"
 )
 
 var (
 	CRLF     = []byte("\r\n")
 	CRLFCRLF = []byte("\r\n\r\n")
+	bSpace   = []byte(" ")
 
 	bTransferEncoding = []byte("Transfer-Encoding")
 	bContentLength    = []byte("Content-Length")
@@ -26,7 +30,23 @@ type RequestHeader struct {
 	RequestURI []byte
 	Proto      []byte
 
+	headerFields
+}
+
+// headerFields 是请求头和响应头共用的header列表，每一项是一行完整的 "Key: value"
+type headerFields struct {
 	headers [][]byte
+	buf     []byte // 读取到的header原始数据，headers 指向这里
+
+	// 序列化的缓存，Add/Set/Del 和读取时失效。首行的字段可以直接修改，
+	// 所以每次都和 line 比较首行
+	cache      []byte
+	cacheFirst int // cache 里首行的长度
+	cacheValid bool
+	line       []byte
+
+	keepRaw bool   // 保存收到的原始数据，见 Request.EnableReplay
+	raw     []byte // 原始的首行和header，包括最后的空行
 }
 
 func NewRequestHeader() (h *RequestHeader) {
@@ -49,10 +69,20 @@ func (h *RequestHeader) Read(r *bufio.Reader) (err error) {
 		}
 		return
 	}
+	if h.keepRaw {
+		h.raw = append(h.raw[:0], b...)
+	}
 	mustDiscard(r, len(b))
 
 	h.parseFirstLine(b[:len(b)-2])
 
+	return h.readFields(r)
+}
+
+// readFields 读取首行之后的所有header，直到空行
+func (h *headerFields) readFields(r *bufio.Reader) (err error) {
+	var b []byte
+
 	// 检查是否firstLine之后就结束了（0个http头）
 	if b, err = r.Peek(2); err != nil {
 		if err == io.EOF && len(b) != 2 {
@@ -61,8 +91,12 @@ func (h *RequestHeader) Read(r *bufio.Reader) (err error) {
 		return
 	}
 	if bytes.Equal(b, CRLF) {
+		if h.keepRaw {
+			h.raw = append(h.raw, b...)
+		}
 		mustDiscard(r, len(b))
 		h.headers = make([][]byte, 3)
+		h.cacheValid = false
 		return
 	}
 
@@ -73,9 +107,16 @@ func (h *RequestHeader) Read(r *bufio.Reader) (err error) {
 		}
 		return
 	}
+
+	// 拷贝出来，否则读取body时bufio.Reader的缓冲区会覆盖这些header
+	h.cacheValid = false
+	h.buf = append(h.buf[:0], b...)
+	if h.keepRaw {
+		h.raw = append(h.raw, b...)
+	}
 	mustDiscard(r, len(b))
 
-	h.headers = splitHeaders(h.headers, b)
+	h.headers = splitHeaders(h.headers, h.buf)
 	for _, header := range h.headers {
 		if len(header) > 0 {
 			normalizeHeaderKey(header)
@@ -89,6 +130,7 @@ func (h *RequestHeader) reset() {
 	h.RequestURI = h.RequestURI[:0]
 	h.Proto = h.Proto[:0]
 	h.headers = h.headers[:0]
+	h.cacheValid = false
 }
 
 func (h *RequestHeader) parseFirstLine(b []byte) error {
@@ -115,7 +157,13 @@ func (h *RequestHeader) parseFirstLine(b []byte) error {
 	return nil
 }
 
-func (h *RequestHeader) VisitFor(key []byte, f func(i int, value []byte) bool) {
+// ProtoAtLeast reports whether the HTTP version of the request is at least major.minor.
+func (h *RequestHeader) ProtoAtLeast(major, minor int) bool {
+	maj, min, ok := http.ParseHTTPVersion(b2s(h.Proto))
+	return ok && (maj > major || maj == major && min >= minor)
+}
+
+func (h *headerFields) VisitFor(key []byte, f func(i int, value []byte) bool) {
 	l := len(key)
 	if l == 0 {
 		return
@@ -137,7 +185,7 @@ func (h *RequestHeader) VisitFor(key []byte, f func(i int, value []byte) bool) {
 	}
 }
 
-func (h *RequestHeader) Get(key []byte) (value []byte) {
+func (h *headerFields) Get(key []byte) (value []byte) {
 	h.VisitFor(key, func(i int, v []byte) bool {
 		value = v
 		return false
@@ -145,7 +193,7 @@ func (h *RequestHeader) Get(key []byte) (value []byte) {
 	return
 }
 
-func (h *RequestHeader) GetChunkedEncoding() (yes bool) { // 32ns
+func (h *headerFields) GetChunkedEncoding() (yes bool) { // 32ns
 	h.VisitFor(bTransferEncoding, func(i int, value []byte) bool {
 		if bytes.Contains(value, bChunked) {
 			yes = true
@@ -157,7 +205,7 @@ func (h *RequestHeader) GetChunkedEncoding() (yes bool) { // 32ns
 	return
 }
 
-func (h *RequestHeader) GetContentLength() (n int) {
+func (h *headerFields) GetContentLength() (n int) {
 	n = -1
 	h.VisitFor(bContentLength, func(i int, value []byte) bool {
 		n, _, _ = parseUintBuf(value)
@@ -167,16 +215,43 @@ func (h *RequestHeader) GetContentLength() (n int) {
 	return
 }
 
-func (h *RequestHeader) Add(key, value []byte) {
+// HasToken reports whether the comma-separated values of key contain token,
+// compared case-insensitively, e.g. HasToken(bConnection, bClose).
+func (h *headerFields) HasToken(key, token []byte) (yes bool) {
+	h.VisitFor(key, func(i int, value []byte) bool {
+		yes = hasToken(value, token)
+		return !yes
+	})
+	return
+}
+
+func hasToken(value, token []byte) bool {
+	for len(value) > 0 {
+		var v []byte
+		if i := bytes.IndexByte(value, ','); i == -1 {
+			v, value = value, nil
+		} else {
+			v, value = value[:i], value[i+1:]
+		}
+		if bytes.EqualFold(bytes.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *headerFields) Add(key, value []byte) {
 	if len(key) == 0 {
 		return
 	}
 	newHeader := append(key, ':', ' ')
 	newHeader = append(newHeader, value...)
 	h.headers = append(h.headers, newHeader)
+	h.cacheValid = false
 }
 
-func (h *RequestHeader) Del(key []byte) (n int) {
+func (h *headerFields) Del(key []byte) (n int) {
+	h.cacheValid = false
 	h.VisitFor(key, func(i int, value []byte) bool {
 		n += 1
 		h.headers[i] = h.headers[i][:0]
@@ -186,7 +261,8 @@ func (h *RequestHeader) Del(key []byte) (n int) {
 	return
 }
 
-func (h *RequestHeader) Set(key, value []byte) (n int) {
+func (h *headerFields) Set(key, value []byte) (n int) {
+	h.cacheValid = false
 	h.VisitFor(key, func(i int, v []byte) bool {
 		h.headers[i] = h.headers[i][:0]
 
@@ -208,39 +284,103 @@ func (h *RequestHeader) Set(key, value []byte) (n int) {
 }
 
 func (h *RequestHeader) Bytes() []byte {
-	// 先计算b的大小，再分配，减少后续append过程中的内存申请次数（计算大小几乎不耗时间）
-	sz := len(h.Method) + len(h.RequestURI) + len(h.Proto) + 4
-	for _, header := range h.headers {
-		if len(header) > 0 {
-			sz += len(header) + 2
-		}
-	}
-	sz += 2
-	b := make([]byte, 0, sz)
+	return h.AppendTo(nil)
+}
+
+// AppendTo appends the serialized header to dst and returns the result.
+// The serialization is cached until the header is changed.
+func (h *RequestHeader) AppendTo(dst []byte) []byte {
+	return append(dst, h.serialized()...)
+}
 
-	// 首行
+func (h *RequestHeader) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(h.serialized())
+	return int64(n), err
+}
+
+func (h *RequestHeader) appendFirstLine(b []byte) []byte {
 	b = append(b, h.Method...)
 	b = append(b, ' ')
 	b = append(b, h.RequestURI...)
 	b = append(b, ' ')
 	b = append(b, h.Proto...)
-	b = append(b, CRLF...)
+	return append(b, CRLF...)
+}
 
-	// headers
+// serialized 返回缓存的序列化结果，不能修改也不能在header变化后继续使用
+func (h *RequestHeader) serialized() []byte {
+	h.line = h.appendFirstLine(h.line[:0])
+	return h.headerFields.serialized(h.line)
+}
+
+// appendBuffers 和 Bytes 的输出相同，但只引用各部分的数据不做拷贝，用于 writev
+func (h *RequestHeader) appendBuffers(bufs net.Buffers) net.Buffers {
+	h.line = h.appendFirstLine(h.line[:0])
+	if b := h.cached(h.line); b != nil {
+		return append(bufs, b)
+	}
+
+	bufs = append(bufs, h.line)
+	for _, header := range h.headers {
+		if len(header) > 0 {
+			bufs = append(bufs, header, CRLF)
+		}
+	}
+	return append(bufs, CRLF)
+}
+
+// cached 在缓存有效且首行没有变化时返回缓存，否则返回nil
+func (h *headerFields) cached(firstLine []byte) []byte {
+	if h.cacheValid && h.cacheFirst == len(firstLine) && bytes.HasPrefix(h.cache, firstLine) {
+		return h.cache
+	}
+	return nil
+}
+
+func (h *headerFields) serialized(firstLine []byte) []byte {
+	if b := h.cached(firstLine); b != nil {
+		return b
+	}
+
+	// 先计算大小，再分配，减少后续append过程中的内存申请次数（计算大小几乎不耗时间）
+	sz := len(firstLine) + 2
+	for _, header := range h.headers {
+		if len(header) > 0 {
+			sz += len(header) + 2
+		}
+	}
+	if cap(h.cache) < sz {
+		h.cache = make([]byte, 0, sz)
+	}
+
+	b := append(h.cache[:0], firstLine...)
 	for _, header := range h.headers {
 		if len(header) > 0 {
 			b = append(b, header...)
 			b = append(b, CRLF...)
 		}
 	}
-
 	b = append(b, CRLF...)
 
+	h.cache, h.cacheFirst, h.cacheValid = b, len(firstLine), true
 	return b
 }
 
-func (h *RequestHeader) WriteTo(w io.Writer) (n int, e error) {
-	return w.Write(h.Bytes())
+var buffersPool sync.Pool
+
+func acquireBuffers() *net.Buffers {
+	if x := buffersPool.Get(); x != nil {
+		return x.(*net.Buffers)
+	}
+	return new(net.Buffers)
+}
+
+func releaseBuffers(bufs *net.Buffers) {
+	for i := range *bufs {
+		(*bufs)[i] = nil
+	}
+	*bufs = (*bufs)[:0]
+	buffersPool.Put(bufs)
 }
 
 func splitHeaders(headers [][]byte, buf []byte) [][]byte {
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -104,6 +105,20 @@ func Test_RequestHeaderGetAddDel(t *testing.T) {
 	fmt.Println(string(b))
 }
 
+func Test_RequestHeader_ReadThenBody(t *testing.T) {
+	// 读取body时 bufio.Reader 的缓冲区被新数据覆盖，header 不能受影响
+	body := strings.Repeat("x", 3*4096)
+	br := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a.com\r\nX-Long: " + strings.Repeat("v", 100) + "\r\n\r\n" + body))
+	h := NewRequestHeader()
+	if !assert.Nil(t, h.Read(br)) {
+		return
+	}
+	b, _ := ioutil.ReadAll(br)
+	assert.Equal(t, body, string(b))
+	assert.Equal(t, []byte("a.com"), h.Get(bHost))
+	assert.Equal(t, strings.Repeat("v", 100), string(h.Get([]byte("X-Long"))))
+}
+
 func Test_splitHeaders(t *testing.T) {
 	lines := []string{
 		"GET / HTTP/1.1",
@@ -146,3 +161,55 @@ func Test_parseHeaderKey(t *testing.T) {
 	assert.Equal(t, []byte("Host"), key)
 	assert.Equal(t, []byte("baidu.com"), value)
 }
+
+func Test_RequestHeader_HasToken(t *testing.T) {
+	h, e := readRequestHeader([]string{
+		"GET / HTTP/1.1",
+		"Connection: keep-alive",
+		"Connection: Upgrade, CLOSE",
+		"\r\n",
+	})
+	assert.Nil(t, e)
+	assert.True(t, h.HasToken(bConnection, []byte("upgrade")))
+	assert.True(t, h.HasToken(bConnection, bClose))
+	assert.True(t, h.HasToken(bConnection, bKeepAlive))
+	assert.False(t, h.HasToken(bConnection, []byte("clos")))
+	assert.False(t, h.HasToken(bHost, bClose))
+
+	assert.True(t, h.ProtoAtLeast(1, 1))
+	assert.True(t, h.ProtoAtLeast(1, 0))
+	assert.False(t, h.ProtoAtLeast(2, 0))
+}
+
+func Test_RequestHeader_AppendTo(t *testing.T) {
+	h, e := readRequestHeader([]string{
+		"GET / HTTP/1.1",
+		"Host: baidu.com",
+		"\r\n",
+	})
+	assert.Nil(t, e)
+
+	b := h.AppendTo([]byte("prefix|"))
+	assert.Equal(t, "prefix|GET / HTTP/1.1\r\nHost: baidu.com\r\n\r\n", string(b))
+
+	// 没有修改时重复序列化不分配内存
+	w := bytes.NewBuffer(make([]byte, 0, 1024))
+	allocs := testing.AllocsPerRun(100, func() {
+		w.Reset()
+		h.WriteTo(w)
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	// Add/Set/Del 以及直接修改首行都会让缓存失效
+	h.Add([]byte("X-A"), []byte("1"))
+	assert.Equal(t, "GET / HTTP/1.1\r\nHost: baidu.com\r\nX-A: 1\r\n\r\n", string(h.Bytes()))
+	h.Set(bHost, []byte("a.com"))
+	assert.Equal(t, "GET / HTTP/1.1\r\nHost: a.com\r\nX-A: 1\r\n\r\n", string(h.Bytes()))
+	h.Del([]byte("X-A"))
+	h.RequestURI = append(h.RequestURI[:0], "/index"...)
+	assert.Equal(t, "GET /index HTTP/1.1\r\nHost: a.com\r\n\r\n", string(h.AppendTo(nil)))
+	h.Method = append(h.Method[:0], "HEAD"...)
+	w.Reset()
+	h.WriteTo(w)
+	assert.Equal(t, "HEAD /index HTTP/1.1\r\nHost: a.com\r\n\r\n", w.String())
+}
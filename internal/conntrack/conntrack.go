@@ -0,0 +1,123 @@
+// Package conntrack keeps the listeners and connections of a server, so that
+// Close can close all of them. It is shared by package http1 and its
+// subpackages.
+package conntrack
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tracker records listeners and connections. The zero value is ready to use.
+type Tracker struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// Serve accepts connections on ln and calls handle for each of them in a new
+// goroutine; handle must call TrackConn(c, false) before it returns. It
+// always returns a non-nil error; after CloseAll it returns
+// http.ErrServerClosed.
+func (t *Tracker) Serve(ln net.Listener, handle func(c net.Conn)) error {
+	if !t.TrackListener(ln, true) {
+		ln.Close()
+		return http.ErrServerClosed
+	}
+	defer t.TrackListener(ln, false)
+
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if t.Closed() {
+				return http.ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+
+		if !t.TrackConn(conn, true) {
+			conn.Close()
+			continue
+		}
+		go handle(conn)
+	}
+}
+
+// CloseAll closes the listeners and connections, and refuses new ones.
+func (t *Tracker) CloseAll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	var err error
+	for ln := range t.listeners {
+		if e := ln.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range t.conns {
+		c.Close()
+	}
+	t.listeners = nil
+	t.conns = nil
+	return err
+}
+
+// Closed reports whether CloseAll has been called.
+func (t *Tracker) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// TrackListener adds or removes ln. Adding fails after CloseAll.
+func (t *Tracker) TrackListener(ln net.Listener, add bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if add {
+		if t.closed {
+			return false
+		}
+		if t.listeners == nil {
+			t.listeners = make(map[net.Listener]struct{})
+		}
+		t.listeners[ln] = struct{}{}
+	} else {
+		delete(t.listeners, ln)
+	}
+	return true
+}
+
+// TrackConn adds or removes c. Adding fails after CloseAll.
+func (t *Tracker) TrackConn(c net.Conn, add bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if add {
+		if t.closed {
+			return false
+		}
+		if t.conns == nil {
+			t.conns = make(map[net.Conn]struct{})
+		}
+		t.conns[c] = struct{}{}
+	} else {
+		delete(t.conns, c)
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+package http1
+
+import (
+	"bufio"
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNotSigner = errors.New("http1: CA private key cannot sign")
+
+// DefaultCertCacheSize and DefaultLeafValidity are used when the fields of
+// CertAuthority are 0.
+const (
+	DefaultCertCacheSize = 1000
+	DefaultLeafValidity  = 30 * 24 * time.Hour
+)
+
+// ALPN 只提供 http/1.1，客户端不会在拦截的连接上使用 h2
+var mitmNextProtos = []string{"http/1.1"}
+
+// CertAuthority mints leaf certificates signed by a local CA for the hosts
+// of intercepted connections, and caches them with LRU eviction. All leaves
+// share one ECDSA P-256 key.
+type CertAuthority struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+
+	CacheSize    int           // 缓存的证书数，为0时使用 DefaultCertCacheSize
+	LeafValidity time.Duration // 为0时使用 DefaultLeafValidity，不超过CA的有效期
+
+	mu      sync.Mutex
+	leafKey *ecdsa.PrivateKey
+	lru     *list.List // *certEntry，最近用过的在前面
+	certs   map[string]*list.Element
+}
+
+type certEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+// NewCertAuthority loads a CA from a PEM certificate and private key.
+func NewCertAuthority(certPEM, keyPEM []byte) (*CertAuthority, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errNotSigner
+	}
+	return &CertAuthority{Cert: cert, Key: key}, nil
+}
+
+// GenerateCA creates a self-signed CA certificate and its ECDSA key, in PEM.
+// Clients of the intercepting proxy must trust the certificate.
+func GenerateCA(commonName string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return
+}
+
+func randSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Certificate returns a certificate for host (a DNS name or an IP address),
+// from the cache or newly minted.
+func (ca *CertAuthority) Certificate(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+
+	ca.mu.Lock()
+	if e, ok := ca.certs[host]; ok {
+		cert := e.Value.(*certEntry).cert
+		if now.Before(cert.Leaf.NotAfter) {
+			ca.lru.MoveToFront(e)
+			ca.mu.Unlock()
+			return cert, nil
+		}
+		ca.lru.Remove(e)
+		delete(ca.certs, host)
+	}
+	if ca.leafKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			ca.mu.Unlock()
+			return nil, err
+		}
+		ca.leafKey = key
+		ca.lru = list.New()
+		ca.certs = make(map[string]*list.Element)
+	}
+	leafKey := ca.leafKey
+	ca.mu.Unlock()
+
+	// 签名比较慢，不持有锁；同一个host并发时可能重复生成，结果都可以使用
+	cert, err := ca.mint(host, leafKey, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if e, ok := ca.certs[host]; ok {
+		ca.lru.MoveToFront(e)
+		return e.Value.(*certEntry).cert, nil
+	}
+	ca.certs[host] = ca.lru.PushFront(&certEntry{host: host, cert: cert})
+
+	size := ca.CacheSize
+	if size <= 0 {
+		size = DefaultCertCacheSize
+	}
+	for ca.lru.Len() > size {
+		e := ca.lru.Back()
+		ca.lru.Remove(e)
+		delete(ca.certs, e.Value.(*certEntry).host)
+	}
+	return cert, nil
+}
+
+func (ca *CertAuthority) mint(host string, key *ecdsa.PrivateKey, now time.Time) (*tls.Certificate, error) {
+	serial, err := randSerial()
+	if err != nil {
+		return nil, err
+	}
+	validity := ca.LeafValidity
+	if validity <= 0 {
+		validity = DefaultLeafValidity
+	}
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// TLSConfig returns a server configuration that mints the certificate for
+// the SNI name of each client, or for defaultHost when the client sends
+// none (e.g. for IP addresses). ALPN is restricted to http/1.1.
+func (ca *CertAuthority) TLSConfig(defaultHost string) *tls.Config {
+	return &tls.Config{
+		NextProtos: mitmNextProtos,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = defaultHost
+			}
+			return ca.Certificate(host)
+		},
+	}
+}
+
+// intercept 回复 CONNECT 之后在客户端连接上终止 TLS，把里面的请求通过 TLS 转发给隧道的目标
+func (pc *proxyConn) intercept(req *Request) {
+	p := pc.p
+	addr, err := requestTarget(req)
+	if err != nil {
+		writeErrorResponse(pc.bw, 400)
+		return
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	if _, err = pc.conn.Write(connectEstablished); err != nil {
+		return
+	}
+	// 客户端可能在收到200之前就发送了 ClientHello
+	conn := RestoreConn(pc.conn, pc.br, nil)
+	if p.ReadTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.ReadTimeout))
+	}
+	tlsConn := tls.Server(conn, p.MITM.TLSConfig(host))
+	if err = tlsConn.Handshake(); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	inner := &proxyConn{
+		p:        p,
+		conn:     tlsConn,
+		br:       bufio.NewReader(tlsConn),
+		bw:       bufio.NewWriter(tlsConn),
+		upstream: addr,
+		pool:     p.upstreamTLSPool(),
+	}
+	inner.serve()
+}
+
+// upstreamTLSPool 是拦截的隧道使用的连接池，连接建立后完成 TLS 握手
+func (p *Proxy) upstreamTLSPool() *ConnPool {
+	p.tlsPoolMu.Lock()
+	defer p.tlsPoolMu.Unlock()
+	if p.tlsPool == nil {
+		dialer := p.Dialer
+		if dialer == nil {
+			dialer = &net.Dialer{Timeout: p.DialTimeout}
+		}
+		p.tlsPool = &ConnPool{Dialer: &tlsDialer{dialer: dialer, config: p.UpstreamTLSConfig}}
+	}
+	return p.tlsPool
+}
+
+type tlsDialer struct {
+	dialer Dialer
+	config *tls.Config
+}
+
+func (d *tlsDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{}
+	if d.config != nil {
+		config = d.config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	config.NextProtos = mitmNextProtos
+
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,125 @@
+package http1
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) (*CertAuthority, *x509.CertPool) {
+	certPEM, keyPEM, e := GenerateCA("http1 test CA", time.Hour)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ca, e := NewCertAuthority(certPEM, keyPEM)
+	if e != nil {
+		t.Fatal(e)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	return ca, roots
+}
+
+func Test_CertAuthority(t *testing.T) {
+	ca, roots := newTestCA(t)
+	ca.CacheSize = 2
+
+	a, e := ca.Certificate("a.example.com")
+	if !assert.Nil(t, e) {
+		return
+	}
+	_, e = a.Leaf.Verify(x509.VerifyOptions{DNSName: "a.example.com", Roots: roots})
+	assert.Nil(t, e)
+	assert.False(t, a.Leaf.NotAfter.After(ca.Cert.NotAfter), "不超过CA的有效期")
+
+	ip, _ := ca.Certificate("127.0.0.1")
+	_, e = ip.Leaf.Verify(x509.VerifyOptions{DNSName: "127.0.0.1", Roots: roots})
+	assert.Nil(t, e)
+
+	a2, _ := ca.Certificate("A.example.com.")
+	assert.True(t, a == a2, "从缓存取得")
+
+	ca.Certificate("b.example.com")
+	assert.Equal(t, 2, ca.lru.Len())
+	_, ok := ca.certs["127.0.0.1"]
+	assert.False(t, ok, "最久没有用过的被淘汰")
+	a3, _ := ca.Certificate("a.example.com")
+	assert.True(t, a == a3)
+}
+
+func Test_Proxy_MITM(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Proto, r.Method, r.URL.RequestURI(), body)
+	}))
+	defer origin.Close()
+
+	ca, roots := newTestCA(t)
+	var mu sync.Mutex
+	var seen []string
+	p := &Proxy{
+		MITM:              ca,
+		UpstreamTLSConfig: &tls.Config{RootCAs: origin.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs},
+		Director: func(req *Request) {
+			mu.Lock()
+			seen = append(seen, req.Method()+" "+req.RequestURI())
+			mu.Unlock()
+		},
+	}
+	defer p.Close()
+	proxyURL, _ := url.Parse("http://" + startProxy(t, p))
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:             http.ProxyURL(proxyURL),
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, e := client.Get(origin.URL + "/a?x=1")
+	if !assert.Nil(t, e) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/1.1 GET /a?x=1 ", string(body))
+	assert.Equal(t, "HTTP/1.1", resp.Proto, "ALPN 只有 http/1.1")
+	assert.Equal(t, "http1 test CA", resp.TLS.PeerCertificates[0].Issuer.CommonName)
+
+	resp, e = client.Post(origin.URL+"/b", "text/plain", strings.NewReader("ping"))
+	if assert.Nil(t, e) {
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/1.1 POST /b ping", string(body))
+	}
+
+	mu.Lock()
+	assert.Equal(t, []string{"GET /a?x=1", "POST /b"}, seen)
+	mu.Unlock()
+
+	// 不拦截的隧道原样转发，客户端看到的是上游的证书
+	p2 := &Proxy{MITM: ca, Intercept: func(req *Request) bool { return false }}
+	defer p2.Close()
+	proxyURL2, _ := url.Parse("http://" + startProxy(t, p2))
+	client2 := origin.Client()
+	client2.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL2)
+	resp, e = client2.Get(origin.URL)
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.NotEqual(t, "http1 test CA", resp.TLS.PeerCertificates[0].Issuer.CommonName)
+	}
+}
+
+func Test_Proxy_CloseWithoutMITM(t *testing.T) {
+	// 没有拦截过隧道时不创建 TLS 连接池
+	p := &Proxy{}
+	assert.Nil(t, p.Close())
+	assert.Nil(t, p.tlsPool)
+}
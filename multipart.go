@@ -0,0 +1,345 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotMultipart       = errors.New("http1: request is not multipart")
+	ErrInvalidBoundary    = errors.New("http1: missing or invalid multipart boundary")
+	ErrInvalidMultipart   = errors.New("http1: malformed multipart body")
+	ErrPartHeaderTooLarge = errors.New("http1: multipart part header too large")
+	ErrTooManyParts       = errors.New("http1: too many multipart parts")
+)
+
+var bContentDisposition = []byte("Content-Disposition")
+
+// DefaultMaxPartHeaderSize is the largest header of a part; DefaultMaxParts
+// is used when MultipartReader.MaxParts is 0.
+const (
+	DefaultMaxPartHeaderSize = 16 << 10
+	DefaultMaxParts          = 1000
+)
+
+// MultipartReader reads the parts of a multipart body (RFC 2046) one after
+// the other, without buffering them.
+type MultipartReader struct {
+	MaxParts int    // 为0时使用 DefaultMaxParts
+	TempDir  string // ReadForm 保存大文件的目录，为空时使用 os.TempDir()
+
+	br    *bufio.Reader
+	delim []byte // "\r\n--boundary"
+	cur   *Part
+	parts int
+	begun bool
+	err   error // 出错或者读完最后一个part之后，NextPart 一直返回它
+}
+
+// PartHeader is the header of a part, parsed like the fields of a request.
+type PartHeader struct {
+	headerFields
+}
+
+// Part is a part of a multipart body. Read returns its content, up to the
+// next boundary.
+type Part struct {
+	Header PartHeader
+
+	mr  *MultipartReader
+	eof bool
+}
+
+// NewMultipartReader reads the parts of r delimited by boundary.
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		br:    bufio.NewReaderSize(r, DefaultMaxPartHeaderSize),
+		delim: []byte("\r\n--" + boundary),
+	}
+}
+
+// MultipartReader returns a reader for the parts of a multipart/* body, with
+// the boundary of its Content-Type, reading from DecodedBody.
+func (m *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := mime.ParseMediaType(string(m.Header.Get(bContentType)))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if len(boundary) == 0 || len(boundary) > 70 {
+		return nil, ErrInvalidBoundary
+	}
+	return NewMultipartReader(m.DecodedBody(), boundary), nil
+}
+
+// NextPart skips what is left of the current part and returns the next one.
+// It returns io.EOF after the last part.
+func (r *MultipartReader) NextPart() (*Part, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.cur != nil {
+		if _, err := io.Copy(ioutil.Discard, r.cur); err != nil {
+			return nil, r.fail(err)
+		}
+		r.cur = nil
+	}
+	if err := r.nextBoundary(); err != nil {
+		return nil, r.fail(err)
+	}
+
+	maxParts := r.MaxParts
+	if maxParts <= 0 {
+		maxParts = DefaultMaxParts
+	}
+	if r.parts >= maxParts {
+		return nil, r.fail(ErrTooManyParts)
+	}
+	r.parts++
+
+	p := &Part{mr: r}
+	if err := p.Header.readFields(r.br); err != nil {
+		if err == bufio.ErrBufferFull {
+			err = ErrPartHeaderTooLarge
+		}
+		return nil, r.fail(err)
+	}
+	r.cur = p
+	return p, nil
+}
+
+func (r *MultipartReader) fail(err error) error {
+	r.err = err
+	return err
+}
+
+// nextBoundary 读取分隔符所在的行，最后一个分隔符（"--boundary--"）之后返回 io.EOF
+func (r *MultipartReader) nextBoundary() error {
+	var line []byte
+	var err error
+	if !r.begun {
+		r.begun = true
+		// 跳过第一个分隔符之前的 preamble，它可以没有前面的 CRLF
+		dashBoundary := r.delim[2:]
+		for partial := false; ; {
+			line, err = r.br.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				partial = true
+				continue
+			}
+			if err != nil {
+				return ErrInvalidMultipart
+			}
+			if !partial && bytes.HasPrefix(line, dashBoundary) {
+				line = line[len(dashBoundary):]
+				break
+			}
+			partial = false
+		}
+	} else {
+		// Part.Read 在分隔符之前结束
+		mustDiscard(r.br, len(r.delim))
+		line, err = r.br.ReadSlice('\n')
+		if err != nil && !bytes.HasPrefix(line, bDashDash) {
+			return ErrInvalidMultipart
+		}
+	}
+
+	if bytes.HasPrefix(line, bDashDash) {
+		return io.EOF
+	}
+	// 分隔符之后只能有空白
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, bLF), bCR)
+	if len(bytes.Trim(line, " \t")) != 0 {
+		return ErrInvalidMultipart
+	}
+	return nil
+}
+
+var (
+	bDashDash = []byte("--")
+	bCR       = []byte("\r")
+	bLF       = []byte("\n")
+)
+
+func (p *Part) Read(b []byte) (n int, err error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	br, delim := p.mr.br, p.mr.delim
+
+	_, perr := br.Peek(len(delim))
+	buf, _ := br.Peek(br.Buffered())
+	i := bytes.Index(buf, delim)
+	switch {
+	case i == 0:
+		p.eof = true
+		return 0, io.EOF
+	case i > 0:
+		buf = buf[:i]
+	case perr != nil:
+		if perr == io.EOF {
+			perr = io.ErrUnexpectedEOF
+		}
+		return 0, perr
+	default:
+		// 结尾可能是分隔符的前一部分
+		buf = buf[:len(buf)-len(delim)+1]
+	}
+	n = copy(b, buf)
+	mustDiscard(br, n)
+	return n, nil
+}
+
+// FormName returns the name parameter of a form-data Content-Disposition.
+func (p *Part) FormName() string {
+	return p.disposition()["name"]
+}
+
+// FileName returns the base name of the filename parameter of
+// Content-Disposition, or "" for parts that are not files.
+func (p *Part) FileName() string {
+	if name := p.disposition()["filename"]; name != "" {
+		return filepath.Base(name)
+	}
+	return ""
+}
+
+// disposition 返回 form-data 的 Content-Disposition 参数
+func (p *Part) disposition() map[string]string {
+	d, params, err := mime.ParseMediaType(string(p.Header.Get(bContentDisposition)))
+	if err != nil || d != "form-data" {
+		return nil
+	}
+	return params
+}
+
+// Form is a multipart form read by ReadForm.
+type Form struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// FileHeader is a file of a Form, kept in memory or in a temporary file.
+type FileHeader struct {
+	Filename string
+	Header   PartHeader
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// Open returns the content of the file.
+func (f *FileHeader) Open() (io.ReadCloser, error) {
+	if f.tmpfile != "" {
+		return os.Open(f.tmpfile)
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll removes the temporary files of the form.
+func (f *Form) RemoveAll() (err error) {
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile != "" {
+				if e := os.Remove(fh.tmpfile); e != nil && err == nil {
+					err = e
+				}
+			}
+		}
+	}
+	return
+}
+
+// ReadForm reads all the parts of a multipart/form-data body. Values and
+// files are kept in memory while their total size is below maxMemory; the
+// other files are written to temporary files, removed by Form.RemoveAll.
+// More than maxSize bytes of part contents fail with ErrBodyTooLarge.
+func (r *MultipartReader) ReadForm(maxMemory, maxSize int64) (*Form, error) {
+	form := &Form{Value: make(map[string][]string), File: make(map[string][]*FileHeader)}
+	if err := r.readForm(form, maxMemory, maxSize); err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (r *MultipartReader) readForm(form *Form, maxMemory, maxSize int64) error {
+	var size, memory int64
+	var buf bytes.Buffer
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+
+		buf.Reset()
+		filename := p.FileName()
+		if filename == "" {
+			n, err := io.Copy(&buf, io.LimitReader(p, maxSize-size+1))
+			if err != nil {
+				return err
+			}
+			if size += n; size > maxSize {
+				return ErrBodyTooLarge
+			}
+			memory += n
+			form.Value[name] = append(form.Value[name], buf.String())
+			continue
+		}
+
+		// 先加到 form 里，出错时临时文件也会被删除
+		fh := &FileHeader{Filename: filename, Header: p.Header}
+		form.File[name] = append(form.File[name], fh)
+		inMemory := maxMemory - memory
+		if inMemory < 0 {
+			inMemory = 0
+		}
+		n, err := io.Copy(&buf, io.LimitReader(p, inMemory+1))
+		if err != nil {
+			return err
+		}
+		if n > inMemory {
+			// 超过内存的限制，写到临时文件
+			if n, err = r.spill(fh, &buf, p, maxSize-size); err != nil {
+				return err
+			}
+		} else {
+			fh.content = append([]byte(nil), buf.Bytes()...)
+			memory += n
+		}
+		fh.Size = n
+		if size += n; size > maxSize {
+			return ErrBodyTooLarge
+		}
+	}
+}
+
+// spill 把已经读到的 buf 和 p 剩下的内容写到临时文件，最多多读1个字节用于判断超过 limit
+func (r *MultipartReader) spill(fh *FileHeader, buf *bytes.Buffer, p *Part, limit int64) (n int64, err error) {
+	f, err := ioutil.TempFile(r.TempDir, "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	fh.tmpfile = f.Name()
+	n, err = io.Copy(f, io.MultiReader(buf, io.LimitReader(p, limit-int64(buf.Len())+1)))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
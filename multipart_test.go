@@ -0,0 +1,169 @@
+package http1
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+)
+
+func buildMultipart(t *testing.T, files map[string]string) (string, []byte) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("title", "hello world")
+	w.WriteField("tag", "a")
+	w.WriteField("tag", "b")
+	for _, name := range []string{"small", "large"} {
+		if content, ok := files[name]; ok {
+			fw, e := w.CreateFormFile(name, "../dir/"+name+".txt")
+			if e != nil {
+				t.Fatal(e)
+			}
+			io.WriteString(fw, content)
+		}
+	}
+	w.Close()
+	return w.Boundary(), body.Bytes()
+}
+
+func Test_MultipartReader_NextPart(t *testing.T) {
+	boundary, body := buildMultipart(t, map[string]string{"small": "line1\r\n--not-boundary\r\n"})
+	body = append([]byte("preamble\r\n"), body...)
+
+	// 每次只读1个字节，分隔符会被拆开
+	r := NewMultipartReader(&oneByteReader{bytes.NewReader(body)}, boundary)
+	var got []string
+	for {
+		p, e := r.NextPart()
+		if e == io.EOF {
+			break
+		}
+		if !assert.Nil(t, e) {
+			return
+		}
+		b, e := ioutil.ReadAll(p)
+		assert.Nil(t, e)
+		got = append(got, p.FormName()+"|"+p.FileName()+"|"+string(b))
+	}
+	assert.Equal(t, []string{"title||hello world", "tag||a", "tag||b", "small|small.txt|line1\r\n--not-boundary\r\n"}, got)
+	_, e := r.NextPart()
+	assert.Equal(t, io.EOF, e)
+
+	// 没有读取的 part 被跳过
+	r = NewMultipartReader(bytes.NewReader(body), boundary)
+	r.NextPart()
+	p, _ := r.NextPart()
+	assert.Equal(t, "tag", p.FormName())
+	assert.Equal(t, "form-data; name=\"tag\"", string(p.Header.Get(bContentDisposition)))
+
+	// 截断的body
+	r = NewMultipartReader(bytes.NewReader(body[:len(body)-20]), boundary)
+	for e == nil || e == io.EOF {
+		if p, e = r.NextPart(); e == nil {
+			_, e = ioutil.ReadAll(p)
+		}
+		if e == io.EOF {
+			t.Fatal("截断的body不应该正常结束")
+		}
+	}
+	assert.Equal(t, io.ErrUnexpectedEOF, e)
+
+	r = NewMultipartReader(bytes.NewReader(body), boundary)
+	r.MaxParts = 2
+	r.NextPart()
+	r.NextPart()
+	_, e = r.NextPart()
+	assert.Equal(t, ErrTooManyParts, e)
+
+	r = NewMultipartReader(strings.NewReader("--b\r\nX: "+strings.Repeat("x", DefaultMaxPartHeaderSize)+"\r\n\r\n"), "b")
+	_, e = r.NextPart()
+	assert.Equal(t, ErrPartHeaderTooLarge, e)
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(b[:1])
+}
+
+func Test_Request_MultipartReader(t *testing.T) {
+	large := strings.Repeat("0123456789", 100)
+	boundary, body := buildMultipart(t, map[string]string{"small": "tiny", "large": large})
+
+	// chunked 的body先被解码
+	var chunked bytes.Buffer
+	for len(body) > 0 {
+		n := 100
+		if n > len(body) {
+			n = len(body)
+		}
+		fmt.Fprintf(&chunked, "%x\r\n%s\r\n", n, body[:n])
+		body = body[n:]
+	}
+	chunked.WriteString("0\r\n\r\n")
+	raw := fmt.Sprintf("POST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=%s\r\nTransfer-Encoding: chunked\r\n\r\n%s", boundary, chunked.String())
+
+	req := readTestRequest(t, raw)
+	defer ReleaseRequest(req)
+	r, e := req.MultipartReader()
+	if !assert.Nil(t, e) {
+		return
+	}
+	dir, _ := ioutil.TempDir("", "http1")
+	defer os.RemoveAll(dir)
+	r.TempDir = dir
+	form, e := r.ReadForm(100, 10000)
+	if !assert.Nil(t, e) {
+		return
+	}
+	assert.Equal(t, map[string][]string{"title": {"hello world"}, "tag": {"a", "b"}}, form.Value)
+
+	small := form.File["small"][0]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(4), small.Size)
+	assert.Equal(t, "", small.tmpfile)
+	f, _ := small.Open()
+	b, _ := ioutil.ReadAll(f)
+	assert.Equal(t, "tiny", string(b))
+
+	fh := form.File["large"][0]
+	assert.Equal(t, int64(len(large)), fh.Size)
+	assert.NotEqual(t, "", fh.tmpfile, "超过 maxMemory 的文件写到磁盘")
+	f, e = fh.Open()
+	if assert.Nil(t, e) {
+		b, _ = ioutil.ReadAll(f)
+		f.Close()
+		assert.Equal(t, large, string(b))
+	}
+	assert.Nil(t, form.RemoveAll())
+	_, e = os.Stat(fh.tmpfile)
+	assert.True(t, os.IsNotExist(e))
+
+	// 超过 maxSize 时临时文件也被删除
+	req = readTestRequest(t, raw)
+	defer ReleaseRequest(req)
+	r, _ = req.MultipartReader()
+	r.TempDir = dir
+	_, e = r.ReadForm(100, 500)
+	assert.Equal(t, ErrBodyTooLarge, e)
+	left, _ := ioutil.ReadDir(dir)
+	assert.Len(t, left, 0)
+
+	req = readTestRequest(t, "POST / HTTP/1.1\r\nContent-Type: multipart/form-data\r\nContent-Length: 0\r\n\r\n")
+	defer ReleaseRequest(req)
+	_, e = req.MultipartReader()
+	assert.Equal(t, ErrInvalidBoundary, e)
+	req.Header.Set(bContentType, []byte("text/plain"))
+	_, e = req.MultipartReader()
+	assert.Equal(t, ErrNotMultipart, e)
+}
@@ -0,0 +1,235 @@
+package http1
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidPath       = errors.New("invalid request path")
+	ErrInvalidPathEscape = errors.New("invalid percent-escape in request path")
+	ErrEncodedNUL        = errors.New("encoded NUL in request path")
+	ErrEncodedSlash      = errors.New("encoded slash in request path")
+)
+
+// EncodedSlashAction 决定路径中 %2F 的处理方式
+type EncodedSlashAction int
+
+const (
+	// EncodedSlashReject 遇到 %2F 直接返回 ErrEncodedSlash
+	EncodedSlashReject EncodedSlashAction = iota
+	// EncodedSlashKeep 保留为 %2F，不作为路径分隔符
+	EncodedSlashKeep
+	// EncodedSlashDecode 解码为 '/'，参与重复斜杠合并和 dot-segment 处理
+	EncodedSlashDecode
+)
+
+type PathPolicy struct {
+	EncodedSlash    EncodedSlashAction
+	AllowEncodedNUL bool // 为true时保留 %00，否则返回 ErrEncodedNUL
+}
+
+// DefaultPathPolicy 拒绝 %2F 和 %00
+var DefaultPathPolicy = &PathPolicy{}
+
+// NormalizedPath returns the path of RequestURI with dot-segments resolved,
+// duplicate slashes collapsed and unreserved percent-escapes decoded.
+// The query string is dropped and RequestURI itself is left untouched.
+// A nil policy means DefaultPathPolicy.
+func (m *Request) NormalizedPath(policy *PathPolicy) (string, error) {
+	b, err := AppendNormalizedPath(nil, m.Header.RequestURI, policy)
+	return string(b), err
+}
+
+// AppendNormalizedPath appends the normalized path of requestURI to dst.
+func AppendNormalizedPath(dst, requestURI []byte, policy *PathPolicy) ([]byte, error) {
+	if policy == nil {
+		policy = DefaultPathPolicy
+	}
+
+	path, err := requestPath(requestURI)
+	if err != nil {
+		return dst, err
+	}
+	if len(path) == 1 && path[0] == '*' {
+		return append(dst, '*'), nil
+	}
+
+	start := len(dst)
+	if dst, err = appendDecodedPath(dst, path, policy); err != nil {
+		return dst[:start], err
+	}
+	return removeDotSegments(dst, start), nil
+}
+
+// requestPath 从 origin-form 或 absolute-form 中取出 path 部分（不含query和fragment）
+func requestPath(ruri []byte) ([]byte, error) {
+	if len(ruri) == 0 {
+		return nil, ErrInvalidPath
+	}
+	if len(ruri) == 1 && ruri[0] == '*' {
+		return ruri, nil
+	}
+
+	path := ruri
+	if ruri[0] != '/' {
+		// GET http://host/path HTTP/1.1
+		begin := indexColonSlashSlash(ruri)
+		if begin == -1 {
+			// CONNECT host:port 没有path
+			return nil, ErrInvalidPath
+		}
+		path = ruri[begin+len(colonSlashSlash):]
+		end := 0
+		for end < len(path) && path[end] != '/' && path[end] != '?' && path[end] != '#' {
+			end++
+		}
+		path = path[end:]
+		if len(path) == 0 || path[0] != '/' {
+			return bSlash, nil
+		}
+	}
+
+	for i, c := range path {
+		if c == '?' || c == '#' {
+			return path[:i], nil
+		}
+	}
+	return path, nil
+}
+
+func indexColonSlashSlash(b []byte) int {
+	// scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+	for i, c := range b {
+		switch {
+		case c == ':':
+			if i > 0 && len(b) >= i+3 && b[i+1] == '/' && b[i+2] == '/' {
+				return i
+			}
+			return -1
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+var bSlash = []byte("/")
+
+const upperHex = "0123456789ABCDEF"
+
+func appendDecodedPath(dst, path []byte, policy *PathPolicy) ([]byte, error) {
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == 0 {
+			return dst, ErrInvalidPath
+		}
+		if c != '%' {
+			dst = append(dst, c)
+			continue
+		}
+
+		if i+2 >= len(path) {
+			return dst, ErrInvalidPathEscape
+		}
+		hi, ok1 := unhex(path[i+1])
+		lo, ok2 := unhex(path[i+2])
+		if !ok1 || !ok2 {
+			return dst, ErrInvalidPathEscape
+		}
+		i += 2
+
+		d := hi<<4 | lo
+		switch {
+		case d == 0:
+			if !policy.AllowEncodedNUL {
+				return dst, ErrEncodedNUL
+			}
+		case d == '/':
+			switch policy.EncodedSlash {
+			case EncodedSlashReject:
+				return dst, ErrEncodedSlash
+			case EncodedSlashDecode:
+				dst = append(dst, '/')
+				continue
+			}
+		case isUnreserved(d):
+			dst = append(dst, d)
+			continue
+		}
+
+		// 其余保持编码，统一为大写十六进制
+		dst = append(dst, '%', upperHex[d>>4], upperHex[d&15])
+	}
+	return dst, nil
+}
+
+// removeDotSegments 原地处理 b[start:]，合并重复的 '/' 并解析 "." 和 ".."
+func removeDotSegments(b []byte, start int) []byte {
+	src := b[start:]
+	n := len(src)
+	w := start
+	trailing := n > 0 && src[n-1] == '/'
+
+	for i := 0; i < n; {
+		for i < n && src[i] == '/' {
+			i++
+		}
+		j := i
+		for j < n && src[j] != '/' {
+			j++
+		}
+		seg := src[i:j]
+		i = j
+
+		switch {
+		case len(seg) == 0:
+		case len(seg) == 1 && seg[0] == '.':
+			if i == n {
+				trailing = true
+			}
+		case len(seg) == 2 && seg[0] == '.' && seg[1] == '.':
+			for w > start && b[w-1] != '/' {
+				w--
+			}
+			if w > start {
+				w--
+			}
+			if i == n {
+				trailing = true
+			}
+		default:
+			// w 总是落后于读取位置，可以安全地原地拷贝
+			b[w] = '/'
+			w += 1 + copy(b[w+1:], seg)
+		}
+	}
+
+	if trailing || w == start {
+		if w == len(b) {
+			b = append(b, '/')
+		} else {
+			b[w] = '/'
+		}
+		w++
+	}
+	return b[:w]
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package http1
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_AppendNormalizedPath(t *testing.T) {
+	cases := []struct {
+		uri  string
+		path string
+	}{
+		{"/", "/"},
+		{"*", "*"},
+		{"/a/b/c", "/a/b/c"},
+		{"/a/../admin", "/admin"},
+		{"//admin", "/admin"},
+		{"/a//b///c/", "/a/b/c/"},
+		{"/%2e%2e/admin", "/admin"},
+		{"/a/%2E%2e/%2e/admin", "/admin"},
+		{"/../../../etc/passwd", "/etc/passwd"},
+		{"/a/b/..", "/a/"},
+		{"/a/b/.", "/a/b/"},
+		{"/a/..", "/"},
+		{"/%61%64%6D%69%6e", "/admin"},
+		{"/a%7e%5f", "/a~_"},
+		{"/a%3fb%3Cc", "/a%3Fb%3Cc"},
+		{"/admin?x=/../y", "/admin"},
+		{"/admin#frag", "/admin"},
+		{"http://example.com/a/../admin?q", "/admin"},
+		{"http://example.com", "/"},
+		{"http://example.com?q=1", "/"},
+		{"HTTPS://example.com:443//admin", "/admin"},
+	}
+
+	for _, c := range cases {
+		b, e := AppendNormalizedPath(nil, []byte(c.uri), nil)
+		assert.Nil(t, e, c.uri)
+		assert.Equal(t, c.path, string(b), c.uri)
+	}
+}
+
+func Test_AppendNormalizedPath_Policy(t *testing.T) {
+	var e error
+	var b []byte
+
+	_, e = AppendNormalizedPath(nil, []byte("/a%2fb"), nil)
+	assert.Equal(t, ErrEncodedSlash, e)
+
+	_, e = AppendNormalizedPath(nil, []byte("/a%00b"), nil)
+	assert.Equal(t, ErrEncodedNUL, e)
+
+	_, e = AppendNormalizedPath(nil, []byte("/a%zz"), nil)
+	assert.Equal(t, ErrInvalidPathEscape, e)
+
+	_, e = AppendNormalizedPath(nil, []byte("/a%2"), nil)
+	assert.Equal(t, ErrInvalidPathEscape, e)
+
+	_, e = AppendNormalizedPath(nil, []byte("google.com:443"), nil)
+	assert.Equal(t, ErrInvalidPath, e)
+
+	b, e = AppendNormalizedPath(nil, []byte("/a%2fb/.."), &PathPolicy{EncodedSlash: EncodedSlashKeep})
+	assert.Nil(t, e)
+	assert.Equal(t, "/", string(b))
+
+	b, e = AppendNormalizedPath(nil, []byte("/a/%2f/b"), &PathPolicy{EncodedSlash: EncodedSlashKeep})
+	assert.Nil(t, e)
+	assert.Equal(t, "/a/%2F/b", string(b))
+
+	b, e = AppendNormalizedPath(nil, []byte("/a%2fb/.."), &PathPolicy{EncodedSlash: EncodedSlashDecode})
+	assert.Nil(t, e)
+	assert.Equal(t, "/a/", string(b))
+
+	b, e = AppendNormalizedPath(nil, []byte("/a%00"), &PathPolicy{AllowEncodedNUL: true})
+	assert.Nil(t, e)
+	assert.Equal(t, "/a%00", string(b))
+
+	// 追加到已有内容后面
+	b, e = AppendNormalizedPath([]byte("GET "), []byte("/x/../y"), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "GET /y", string(b))
+}
+
+func Test_Request_NormalizedPath(t *testing.T) {
+	req := NewRequest("GET", "/static/..//admin/%2e/users", nil)
+	path, e := req.NormalizedPath(nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "/admin/users", path)
+	assert.Equal(t, "/static/..//admin/%2e/users", req.RequestURI())
+}
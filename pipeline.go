@@ -0,0 +1,199 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+)
+
+type pipelinedRequest struct {
+	req *Request
+	err error
+}
+
+// PipelineReader parses up to depth requests ahead from one bufio.Reader.
+// Body readers share the bufio.Reader, so before the next request can be
+// parsed the body of the previous one is read into memory (at most
+// maxBodySize bytes). Requests returned by Next are released by the caller.
+//
+// A client sends the body of a request with "Expect: 100-continue" only
+// after "100 Continue", and what follows CONNECT or an upgrade request is
+// not HTTP/1; such a request is returned with its body left in br and
+// nothing is read ahead after it: Next then returns io.EOF, and once the
+// caller has handled the request it can go on reading br, e.g. with a new
+// PipelineReader.
+type PipelineReader struct {
+	br          *bufio.Reader
+	maxBodySize int64
+
+	reqs chan pipelinedRequest
+	done chan struct{}
+	once sync.Once
+	seq  uint64
+}
+
+func NewPipelineReader(br *bufio.Reader, depth int, maxBodySize int64) *PipelineReader {
+	if depth < 1 {
+		depth = 1
+	}
+	p := &PipelineReader{
+		br:          br,
+		maxBodySize: maxBodySize,
+		reqs:        make(chan pipelinedRequest, depth-1),
+		done:        make(chan struct{}),
+	}
+	go p.readLoop()
+	return p
+}
+
+func (p *PipelineReader) readLoop() {
+	defer close(p.reqs)
+
+	for {
+		req := AcquireRequest()
+		last := true
+		err := req.Read(p.br)
+		if err == nil {
+			// 必须在替换body之前判断
+			last = req.ShouldClose()
+			if req.ExpectContinue() || req.IsUpgrade() || bytes.Equal(req.Header.Method, bCONNECT) {
+				// body 要等 100 Continue 之后才会发送，隧道和协议升级之后的数据不是请求，都不能预先读取
+				last = true
+			} else {
+				err = p.bufferBody(req)
+			}
+		}
+		if err != nil {
+			ReleaseRequest(req)
+			req = nil
+		}
+
+		select {
+		case p.reqs <- pipelinedRequest{req, err}:
+		case <-p.done:
+			if req != nil {
+				ReleaseRequest(req)
+			}
+			return
+		}
+
+		// 出错或者连接不再复用，后面不会再有请求
+		if err != nil || last {
+			return
+		}
+	}
+}
+
+// bufferBody 把body读入内存，让bufio.Reader停在下一个请求的开头
+func (p *PipelineReader) bufferBody(req *Request) error {
+	switch req.rawBody().(type) {
+	case *chunkedReader, *io.LimitedReader:
+	default:
+		return nil
+	}
+
+	// chunked 的body解码后保存，这样 req.Body 就是长度已知的普通body
+	_, chunked := req.rawBody().(*chunkedReader)
+	b, err := ioutil.ReadAll(io.LimitReader(req.DecodedBody(), p.maxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > p.maxBodySize {
+		return ErrBodyTooLarge
+	}
+	if chunked {
+		req.Header.Del(bTransferEncoding)
+		req.Header.Set(bContentLength, strconv.AppendInt(nil, int64(len(b)), 10))
+	}
+
+	req.resetBody()
+	req.Body = bytes.NewReader(b)
+	return nil
+}
+
+// Next returns the next request together with its sequence number, which
+// starts at 0 and is used to order the responses in a ResponseQueue.
+func (p *PipelineReader) Next() (req *Request, seq uint64, err error) {
+	r, ok := <-p.reqs
+	if !ok {
+		return nil, 0, io.EOF
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	seq = p.seq
+	p.seq++
+	return r.req, seq, nil
+}
+
+// Close stops reading ahead and releases requests not yet returned by Next.
+// A read blocked on the connection only returns when the connection is closed.
+func (p *PipelineReader) Close() {
+	p.once.Do(func() {
+		close(p.done)
+		go func() {
+			for r := range p.reqs {
+				if r.req != nil {
+					ReleaseRequest(r.req)
+				}
+			}
+		}()
+	})
+}
+
+// ResponseQueue writes responses in request order, no matter in which order
+// the handlers of pipelined requests finish.
+type ResponseQueue struct {
+	mu      sync.Mutex
+	w       io.Writer
+	next    uint64
+	pending map[uint64][]byte
+	err     error
+}
+
+func NewResponseQueue(w io.Writer) *ResponseQueue {
+	return &ResponseQueue{w: w, pending: make(map[uint64][]byte)}
+}
+
+// Write queues the complete response b for the request with sequence
+// number seq. It is written as soon as all earlier responses have been.
+func (q *ResponseQueue) Write(seq uint64, b []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+	if seq != q.next {
+		q.pending[seq] = append([]byte(nil), b...)
+		return nil
+	}
+
+	for {
+		if _, q.err = q.w.Write(b); q.err != nil {
+			return q.err
+		}
+		q.next++
+
+		var ok bool
+		if b, ok = q.pending[q.next]; !ok {
+			break
+		}
+		delete(q.pending, q.next)
+	}
+
+	if f, ok := q.w.(*bufio.Writer); ok {
+		q.err = f.Flush()
+	}
+	return q.err
+}
+
+// Pending returns the number of responses waiting for earlier ones.
+func (q *ResponseQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
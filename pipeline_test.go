@@ -0,0 +1,211 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_PipelineReader_100(t *testing.T) {
+	const total = 100
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// 客户端一次性发出所有请求，中间夹杂带body的请求
+	go func() {
+		for i := 0; i < total; i++ {
+			if i%10 == 0 {
+				body := fmt.Sprintf("body-%d", i)
+				fmt.Fprintf(client, "POST /%d HTTP/1.1\r\nHost: a\r\nContent-Length: %d\r\n\r\n%s", i, len(body), body)
+			} else {
+				fmt.Fprintf(client, "GET /%d HTTP/1.1\r\nHost: a\r\n\r\n", i)
+			}
+		}
+	}()
+
+	go func() {
+		defer server.Close()
+
+		p := NewPipelineReader(bufio.NewReader(server), 8, 1024)
+		defer p.Close()
+		q := NewResponseQueue(bufio.NewWriter(server))
+
+		var wg sync.WaitGroup
+		for {
+			req, seq, e := p.Next()
+			if e != nil {
+				break
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer ReleaseRequest(req)
+
+				// 后到的请求可能先处理完
+				time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+				body, _ := ioutil.ReadAll(req.Body)
+				content := req.RequestURI() + string(body)
+
+				resp := NewResponseHeader()
+				resp.StatusCode = 200
+				resp.Set(bContentLength, []byte(strconv.Itoa(len(content))))
+				q.Write(seq, append(resp.Bytes(), content...))
+			}()
+
+			if seq == total-1 {
+				break
+			}
+		}
+		wg.Wait()
+		assert.Equal(t, 0, q.Pending())
+	}()
+
+	br := bufio.NewReader(client)
+	for i := 0; i < total; i++ {
+		resp, e := http.ReadResponse(br, nil)
+		assert.Nil(t, e)
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		expected := fmt.Sprintf("/%d", i)
+		if i%10 == 0 {
+			expected += fmt.Sprintf("body-%d", i)
+		}
+		assert.Equal(t, expected, string(body))
+	}
+}
+
+func Test_PipelineReader_BodyTooLarge(t *testing.T) {
+	br := bufio.NewReader(bytes.NewReader([]byte(
+		"GET /1 HTTP/1.1\r\n\r\nPOST /2 HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789")))
+	p := NewPipelineReader(br, 4, 5)
+	defer p.Close()
+
+	req, seq, e := p.Next()
+	assert.Nil(t, e)
+	assert.Equal(t, uint64(0), seq)
+	assert.Equal(t, "/1", req.RequestURI())
+
+	_, _, e = p.Next()
+	assert.Equal(t, ErrBodyTooLarge, e)
+
+	_, _, e = p.Next()
+	assert.Equal(t, io.EOF, e)
+}
+
+func Test_PipelineReader_Chunked(t *testing.T) {
+	br := bufio.NewReader(bytes.NewReader([]byte(
+		"POST /1 HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\nGET /2 HTTP/1.1\r\n\r\n")))
+	p := NewPipelineReader(br, 4, 100)
+	defer p.Close()
+
+	// chunked 的body被解码，header 改为 Content-Length
+	req, _, e := p.Next()
+	assert.Nil(t, e)
+	assert.False(t, req.Header.GetChunkedEncoding())
+	assert.Equal(t, 3, req.Header.GetContentLength())
+	b, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "abc", string(b))
+
+	req, _, e = p.Next()
+	assert.Nil(t, e)
+	assert.Equal(t, "/2", req.RequestURI())
+}
+
+func Test_PipelineReader_ExpectContinue(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go fmt.Fprintf(client, "GET /1 HTTP/1.1\r\n\r\nPOST /2 HTTP/1.1\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n")
+
+	br := bufio.NewReader(server)
+	p := NewPipelineReader(br, 4, 100)
+	defer p.Close()
+
+	req, _, e := p.Next()
+	assert.Nil(t, e)
+	assert.Equal(t, "/1", req.RequestURI())
+
+	// 客户端还没有发送body，请求不等body就返回，之后不再预读
+	req, _, e = p.Next()
+	if !assert.Nil(t, e) {
+		return
+	}
+	assert.Equal(t, "/2", req.RequestURI())
+	_, _, e = p.Next()
+	assert.Equal(t, io.EOF, e)
+
+	go func() {
+		b := make([]byte, len(continueResponse))
+		io.ReadFull(client, b)
+		client.Write([]byte("abc"))
+	}()
+	req.EnableAutoContinue(server)
+	b, e := ioutil.ReadAll(req.Body)
+	assert.Nil(t, e)
+	assert.Equal(t, "abc", string(b))
+}
+
+func Test_PipelineReader_Connect(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go fmt.Fprintf(client, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\nCONNECT a:443 HTTP/1.1\r\nHost: a:443\r\n\r\n")
+
+	br := bufio.NewReader(server)
+	p := NewPipelineReader(br, 4, 100)
+	defer p.Close()
+
+	req, _, e := p.Next()
+	assert.Nil(t, e)
+	assert.Equal(t, "/1", req.RequestURI())
+
+	// CONNECT 不等隧道结束就返回，之后的数据属于隧道
+	req, _, e = p.Next()
+	if !assert.Nil(t, e) {
+		return
+	}
+	assert.Equal(t, "CONNECT", req.Method())
+	_, _, e = p.Next()
+	assert.Equal(t, io.EOF, e)
+
+	go func() {
+		io.WriteString(server, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	}()
+	resp, e := http.ReadResponse(bufio.NewReader(client), nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+	go client.Write([]byte("tunnel"))
+	b := make([]byte, 6)
+	_, e = io.ReadFull(br, b)
+	assert.Nil(t, e)
+	assert.Equal(t, "tunnel", string(b))
+}
+
+func Test_ResponseQueue_Order(t *testing.T) {
+	w := bytes.NewBuffer(nil)
+	q := NewResponseQueue(w)
+
+	q.Write(2, []byte("c"))
+	q.Write(1, []byte("b"))
+	assert.Equal(t, "", w.String())
+	assert.Equal(t, 2, q.Pending())
+
+	q.Write(0, []byte("a"))
+	assert.Equal(t, "abc", w.String())
+	assert.Equal(t, 0, q.Pending())
+
+	q.Write(3, []byte("d"))
+	assert.Equal(t, "abcd", w.String())
+}
@@ -0,0 +1,286 @@
+package http1
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxIdlePerHost is used when ConnPool.MaxIdlePerHost is 0.
+const DefaultMaxIdlePerHost = 2
+
+var ErrPoolClosed = errors.New("http1: connection pool closed")
+
+// ConnPool keeps idle keep-alive connections to upstream servers, keyed by
+// host:port. A connection taken with Get is owned by the caller until it is
+// given back with Put, or closed when it cannot be reused.
+type ConnPool struct {
+	Dialer      Dialer // nil means net.Dialer with DialTimeout
+	DialTimeout time.Duration
+
+	MaxIdlePerHost int           // 每个 host:port 最多保留的空闲连接数，为0时使用 DefaultMaxIdlePerHost，小于0不保留
+	IdleTimeout    time.Duration // 空闲超过这个时间的连接被关闭，为0时不限制
+
+	mu     sync.Mutex
+	idle   map[string][]*PoolConn
+	closed bool
+
+	dials, dialErrors, hits, stale, expired, discarded int64
+}
+
+// PoolStats are counters of a ConnPool since it was created.
+type PoolStats struct {
+	Dials      int64 // 新建立的连接
+	DialErrors int64
+	Hits       int64 // 复用的空闲连接
+	Stale      int64 // 取出时发现已被对方关闭（或有多余数据）的连接
+	Expired    int64 // 因 IdleTimeout 关闭的连接
+	Discarded  int64 // 因超过 MaxIdlePerHost 没有放回的连接
+	Idle       int   // 当前空闲的连接数
+}
+
+// PoolConn is a connection owned by a ConnPool. Reader and Writer must be
+// used for all I/O so that buffered data is not lost between requests.
+type PoolConn struct {
+	net.Conn
+	Reader *bufio.Reader
+	Writer *bufio.Writer
+
+	key    string
+	reused bool
+	timer  *time.Timer
+}
+
+// Key returns the host:port the connection was dialed for.
+func (c *PoolConn) Key() string {
+	return c.key
+}
+
+// Reused reports whether the connection already carried a request before
+// this checkout. A reused connection may have been closed by the server
+// right after the health check, so only requests that are safe to replay
+// should be retried on it.
+func (c *PoolConn) Reused() bool {
+	return c.reused
+}
+
+// Get returns an idle connection to addr (host:port) that passes the health
+// check, or dials a new one.
+func (p *ConnPool) Get(addr string) (*PoolConn, error) {
+	for {
+		c, err := p.takeIdle(addr)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+		if c.alive() {
+			atomic.AddInt64(&p.hits, 1)
+			return c, nil
+		}
+		atomic.AddInt64(&p.stale, 1)
+		c.Conn.Close()
+	}
+
+	dialer := p.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: p.DialTimeout}
+	}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		atomic.AddInt64(&p.dialErrors, 1)
+		return nil, err
+	}
+	atomic.AddInt64(&p.dials, 1)
+	return &PoolConn{
+		Conn:   conn,
+		Reader: bufio.NewReader(conn),
+		Writer: bufio.NewWriter(conn),
+		key:    addr,
+	}, nil
+}
+
+// GetFor is Get with the host:port of req, see Request.GetHostPort.
+func (p *ConnPool) GetFor(req *Request) (*PoolConn, error) {
+	host, port, err := req.GetHostPort()
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// Put gives c back to the pool. The last response must have been read
+// completely; connections with unread data, or over MaxIdlePerHost, are
+// closed instead.
+func (p *ConnPool) Put(c *PoolConn) {
+	if c.Reader.Buffered() > 0 || c.Writer.Buffered() > 0 {
+		atomic.AddInt64(&p.discarded, 1)
+		c.Conn.Close()
+		return
+	}
+	max := p.MaxIdlePerHost
+	if max == 0 {
+		max = DefaultMaxIdlePerHost
+	}
+
+	p.mu.Lock()
+	if p.closed || len(p.idle[c.key]) >= max {
+		p.mu.Unlock()
+		atomic.AddInt64(&p.discarded, 1)
+		c.Conn.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = make(map[string][]*PoolConn)
+	}
+	c.reused = true
+	if p.IdleTimeout > 0 {
+		c.timer = time.AfterFunc(p.IdleTimeout, func() { p.expire(c) })
+	}
+	p.idle[c.key] = append(p.idle[c.key], c)
+	p.mu.Unlock()
+}
+
+// takeIdle 取出最近放回的空闲连接，没有时返回nil
+func (p *ConnPool) takeIdle(addr string) (*PoolConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	conns := p.idle[addr]
+	if len(conns) == 0 {
+		return nil, nil
+	}
+	c := conns[len(conns)-1]
+	conns[len(conns)-1] = nil
+	if len(conns) == 1 {
+		delete(p.idle, addr)
+	} else {
+		p.idle[addr] = conns[:len(conns)-1]
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return c, nil
+}
+
+func (p *ConnPool) expire(c *PoolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[c.key]
+	for i, ic := range conns {
+		if ic == c {
+			copy(conns[i:], conns[i+1:])
+			conns[len(conns)-1] = nil
+			if len(conns) == 1 {
+				delete(p.idle, c.key)
+			} else {
+				p.idle[c.key] = conns[:len(conns)-1]
+			}
+			atomic.AddInt64(&p.expired, 1)
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+// alive 检查空闲连接：对方关闭或发送了多余数据的连接不能再使用
+func (c *PoolConn) alive() bool {
+	if c.Reader.Buffered() > 0 {
+		return false
+	}
+	if alive, ok := peekConn(c.Conn); ok {
+		return alive
+	}
+
+	// 无法直接读fd时（比如 tls.Conn）用很短的读超时代替非阻塞读。
+	// 不能用已经过期的 deadline：那样 Read 不做系统调用直接返回超时，对方关闭了也发现不了；
+	// 1ms 足够读到已经在内核缓冲区里的 FIN 或数据，没有数据时最多等这么久
+	c.Conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := c.Reader.Peek(1)
+	c.Conn.SetReadDeadline(time.Time{})
+
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Stats returns the counters of p.
+func (p *ConnPool) Stats() PoolStats {
+	p.mu.Lock()
+	idle := 0
+	for _, conns := range p.idle {
+		idle += len(conns)
+	}
+	p.mu.Unlock()
+
+	return PoolStats{
+		Dials:      atomic.LoadInt64(&p.dials),
+		DialErrors: atomic.LoadInt64(&p.dialErrors),
+		Hits:       atomic.LoadInt64(&p.hits),
+		Stale:      atomic.LoadInt64(&p.stale),
+		Expired:    atomic.LoadInt64(&p.expired),
+		Discarded:  atomic.LoadInt64(&p.discarded),
+		Idle:       idle,
+	}
+}
+
+// CloseIdle closes all idle connections. The pool can still be used.
+func (p *ConnPool) CloseIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, c := range conns {
+			if c.timer != nil {
+				c.timer.Stop()
+			}
+			c.Conn.Close()
+		}
+	}
+}
+
+// Close closes all idle connections; later Get calls fail with ErrPoolClosed
+// and connections given back with Put are closed.
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	p.CloseIdle()
+	return nil
+}
+
+// roundTrip 在 c 上发送请求并读取最终响应的header，1xx 中间响应交给 interim 处理
+func (c *PoolConn) roundTrip(req *Request, resp *Response, interim func(resp *Response) error) (err error) {
+	if c.Writer.Buffered() == 0 {
+		// 直接写连接，*net.TCPConn 可以用 writev 和 splice
+		_, err = req.WriteTo(c.Conn)
+	} else if _, err = req.WriteTo(c.Writer); err == nil {
+		err = c.Writer.Flush()
+	}
+	for err == nil {
+		if err = resp.Read(c.Reader, req); err != nil {
+			return
+		}
+		status := resp.Header.StatusCode
+		if status >= 200 || status == http.StatusSwitchingProtocols {
+			return
+		}
+		if interim != nil {
+			err = interim(resp)
+		}
+	}
+	return
+}
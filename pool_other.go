@@ -0,0 +1,9 @@
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package http1
+
+import "net"
+
+func peekConn(conn net.Conn) (alive, ok bool) {
+	return false, false
+}
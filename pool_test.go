@@ -0,0 +1,143 @@
+package http1
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// startHoldServer 接受连接但不发送数据，closeConns 为true时立即关闭连接
+func startHoldServer(t *testing.T, closeConns bool) net.Listener {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			if closeConns {
+				conn.Close()
+			}
+		}
+	}()
+	return ln
+}
+
+func Test_ConnPool(t *testing.T) {
+	ln := startHoldServer(t, false)
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	p := &ConnPool{MaxIdlePerHost: 1}
+	defer p.Close()
+
+	c1, e := p.Get(addr)
+	assert.Nil(t, e)
+	assert.False(t, c1.Reused())
+	assert.Equal(t, addr, c1.Key())
+	c2, e := p.Get(addr)
+	assert.Nil(t, e)
+
+	p.Put(c1)
+	p.Put(c2) // 超过 MaxIdlePerHost
+	assert.Equal(t, PoolStats{Dials: 2, Discarded: 1, Idle: 1}, p.Stats())
+
+	c, e := p.Get(addr)
+	assert.Nil(t, e)
+	assert.True(t, c == c1)
+	assert.True(t, c.Reused())
+	p.Put(c)
+
+	req := NewRequest("GET", "http://"+addr+"/", nil)
+	c, e = p.GetFor(req)
+	assert.Nil(t, e)
+	assert.True(t, c == c1)
+	c.Close()
+	assert.Equal(t, PoolStats{Dials: 2, Hits: 2, Discarded: 1}, p.Stats())
+
+	p.Close()
+	_, e = p.Get(addr)
+	assert.Equal(t, ErrPoolClosed, e)
+}
+
+func Test_ConnPool_Stale(t *testing.T) {
+	ln := startHoldServer(t, true)
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	p := &ConnPool{}
+	defer p.Close()
+
+	c, e := p.Get(addr)
+	assert.Nil(t, e)
+	p.Put(c)
+	time.Sleep(50 * time.Millisecond) // 等待服务端关闭连接
+
+	c2, e := p.Get(addr)
+	assert.Nil(t, e)
+	assert.False(t, c2 == c)
+	assert.False(t, c2.Reused())
+	c2.Close()
+
+	s := p.Stats()
+	assert.Equal(t, int64(1), s.Stale)
+	assert.Equal(t, int64(2), s.Dials)
+}
+
+// plainDialer 返回的连接隐藏了 syscall.Conn，alive 只能用读超时检查
+type plainDialer struct{}
+
+func (plainDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, e := net.Dial(network, addr)
+	if e != nil {
+		return nil, e
+	}
+	return struct{ net.Conn }{conn}, nil
+}
+
+func Test_ConnPool_StaleNoFd(t *testing.T) {
+	for _, closeConns := range []bool{false, true} {
+		ln := startHoldServer(t, closeConns)
+		addr := ln.Addr().String()
+		p := &ConnPool{Dialer: plainDialer{}}
+
+		c, e := p.Get(addr)
+		assert.Nil(t, e)
+		p.Put(c)
+		time.Sleep(50 * time.Millisecond)
+
+		c2, e := p.Get(addr)
+		assert.Nil(t, e)
+		assert.Equal(t, !closeConns, c2 == c)
+		assert.Equal(t, !closeConns, c2.Reused())
+		c2.Close()
+		if closeConns {
+			assert.Equal(t, int64(1), p.Stats().Stale)
+		}
+
+		p.Close()
+		ln.Close()
+	}
+}
+
+func Test_ConnPool_IdleTimeout(t *testing.T) {
+	ln := startHoldServer(t, false)
+	defer ln.Close()
+
+	p := &ConnPool{IdleTimeout: 50 * time.Millisecond}
+	defer p.Close()
+
+	c, e := p.Get(ln.Addr().String())
+	assert.Nil(t, e)
+	p.Put(c)
+	assert.Equal(t, 1, p.Stats().Idle)
+
+	time.Sleep(150 * time.Millisecond)
+	s := p.Stats()
+	assert.Equal(t, 0, s.Idle)
+	assert.Equal(t, int64(1), s.Expired)
+}
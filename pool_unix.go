@@ -0,0 +1,32 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package http1
+
+import (
+	"net"
+	"syscall"
+)
+
+// peekConn 用 MSG_PEEK|MSG_DONTWAIT 做非阻塞读，不消耗数据。ok 为false表示 conn 不支持
+func peekConn(conn net.Conn) (alive, ok bool) {
+	sc, isSyscallConn := conn.(syscall.Conn)
+	if !isSyscallConn {
+		return false, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+
+	var buf [1]byte
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		_, _, rerr = syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		return true
+	})
+	if err != nil {
+		return false, true
+	}
+	// 读到EOF或数据都说明连接不再空闲，只有 EAGAIN 是正常的
+	return rerr == syscall.EAGAIN || rerr == syscall.EWOULDBLOCK, true
+}
@@ -0,0 +1,448 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib-go/http1/internal/conntrack"
+)
+
+var errUnsupportedScheme = errors.New("http1: unsupported scheme in request target")
+
+var (
+	bHTTP               = []byte("http")
+	bProxyConnection    = []byte("Proxy-Connection")
+	bProxyAuthenticate  = []byte("Proxy-Authenticate")
+	bProxyAuthorization = []byte("Proxy-Authorization")
+
+	// 逐跳的header（RFC 7230 6.1）。body按原样转发，所以保留 Transfer-Encoding 和 Trailer
+	hopHeaders = [][]byte{
+		bConnection,
+		bProxyConnection,
+		[]byte("Keep-Alive"),
+		bProxyAuthenticate,
+		bProxyAuthorization,
+		[]byte("Te"),
+	}
+)
+
+// Proxy is an HTTP/1.1 proxy. CONNECT requests are tunnelled with Tunnel,
+// other requests are forwarded to the server named in the request, or to
+// Upstream when it is set (reverse proxy). Bodies are relayed as they are
+// on the wire and connections are kept alive on both legs when possible.
+type Proxy struct {
+	// Upstream is the host:port every request is sent to. If empty, the
+	// target comes from the request and CONNECT is allowed.
+	Upstream string
+
+	// Director, if set, may modify each request after the hop-by-hop
+	// headers are removed and before it is sent upstream.
+	Director func(req *Request)
+
+	// Rewriter, if set, applies its rules to each request before Director,
+	// and to each response; requests it redirects or rejects are answered
+	// by the proxy.
+	Rewriter *Rewriter
+
+	// Auth, if set, requires clients to authenticate with
+	// Proxy-Authorization; other requests are answered with 407.
+	Auth Authenticator
+
+	// Pool holds the upstream connections. If nil, the proxy uses a pool of
+	// its own built from Dialer and DialTimeout, closed by Close.
+	Pool *ConnPool
+
+	Dialer      Dialer // nil means net.Dialer with DialTimeout
+	DialTimeout time.Duration
+
+	// MITM, if set, intercepts CONNECT tunnels: TLS is terminated with a
+	// certificate minted by MITM, and the requests inside are forwarded
+	// like plain ones over TLS to the CONNECT target. Intercept, if set,
+	// selects the tunnels to intercept; the others are relayed unchanged.
+	MITM      *CertAuthority
+	Intercept func(req *Request) bool
+
+	// UpstreamTLSConfig is used to connect to intercepted servers; nil
+	// means the default configuration.
+	UpstreamTLSConfig *tls.Config
+
+	ReadTimeout       time.Duration // 读取客户端单个请求头的超时
+	ReadBodyTimeout   time.Duration // 读取客户端单个请求body的超时，为0时使用 ReadTimeout
+	IdleTimeout       time.Duration // 客户端 keep-alive 时等待下一个请求的超时，为0时使用 ReadTimeout
+	TunnelIdleTimeout time.Duration // CONNECT 隧道和协议升级之后的空闲超时
+
+	tracker conntrack.Tracker
+
+	poolOnce sync.Once
+	ownPool  *ConnPool
+
+	tlsPoolMu sync.Mutex
+	tlsPool   *ConnPool // 第一次拦截隧道时创建
+}
+
+func (p *Proxy) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each of them in a new goroutine.
+// It always returns a non-nil error; after Close it returns http.ErrServerClosed.
+func (p *Proxy) Serve(ln net.Listener) error {
+	return p.tracker.Serve(ln, func(conn net.Conn) {
+		defer p.tracker.TrackConn(conn, false)
+		p.ServeConn(conn)
+	})
+}
+
+// Close closes all listeners, client connections and upstream connections
+// in use by p. Idle connections of a Pool set by the caller are left open.
+func (p *Proxy) Close() error {
+	err := p.tracker.CloseAll()
+	if p.Pool == nil {
+		p.pool().Close()
+	}
+	p.tlsPoolMu.Lock()
+	tlsPool := p.tlsPool
+	p.tlsPoolMu.Unlock()
+	if tlsPool != nil {
+		tlsPool.Close()
+	}
+	return err
+}
+
+func (p *Proxy) pool() *ConnPool {
+	if p.Pool != nil {
+		return p.Pool
+	}
+	p.poolOnce.Do(func() {
+		p.ownPool = &ConnPool{Dialer: p.Dialer, DialTimeout: p.DialTimeout}
+	})
+	return p.ownPool
+}
+
+// ServeConn serves the requests of a single client connection and closes
+// it when done. It is useful for connections accepted elsewhere.
+func (p *Proxy) ServeConn(conn net.Conn) {
+	pc := &proxyConn{
+		p:        p,
+		conn:     conn,
+		br:       bufio.NewReader(conn),
+		bw:       bufio.NewWriter(conn),
+		upstream: p.Upstream,
+		pool:     p.pool(),
+		auth:     p.Auth,
+	}
+	pc.serve()
+}
+
+// serve 读取并转发客户端的请求直到连接结束
+func (pc *proxyConn) serve() {
+	p, conn := pc.p, pc.conn
+	defer conn.Close()
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetBodyConn(conn)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	for first := true; ; first = false {
+		timeout := p.ReadTimeout
+		if !first && p.IdleTimeout > 0 {
+			timeout = p.IdleTimeout
+		}
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		if err := req.Read(pc.br); err != nil {
+			if err != io.EOF {
+				writeErrorResponse(pc.bw, http.StatusBadRequest)
+			}
+			return
+		}
+		// body 的读取也要有期限，否则客户端可以一直占用连接和上游连接；隧道有自己的空闲超时
+		if timeout = p.ReadBodyTimeout; timeout == 0 {
+			timeout = p.ReadTimeout
+		}
+		if timeout > 0 && !bytes.Equal(req.Header.Method, bCONNECT) {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		if pc.auth != nil {
+			if _, keepAlive, ok := ProxyAuthenticate(pc.auth, req, pc.bw); !ok {
+				if keepAlive {
+					continue
+				}
+				return
+			}
+		}
+
+		// CONNECT 也要经过规则，否则拒绝访问的规则对 HTTPS 隧道不起作用
+		if p.Rewriter != nil {
+			if status, location := p.Rewriter.RewriteRequest(req); status != 0 {
+				if !pc.reply(req, status, location, req.ShouldClose()) {
+					return
+				}
+				continue
+			}
+		}
+
+		if bytes.Equal(req.Header.Method, bCONNECT) {
+			switch {
+			case pc.upstream != "":
+				writeErrorResponse(pc.bw, http.StatusMethodNotAllowed)
+			case p.MITM != nil && (p.Intercept == nil || p.Intercept(req)):
+				pc.intercept(req)
+			default:
+				Tunnel(req, conn, pc.br, &TunnelOptions{
+					Dialer:      p.Dialer,
+					DialTimeout: p.DialTimeout,
+					IdleTimeout: p.TunnelIdleTimeout,
+				})
+			}
+			return
+		}
+
+		if !pc.forward(req, resp) {
+			return
+		}
+	}
+}
+
+// proxyConn 是一个客户端连接的状态。upstream 不为空时所有请求都发给它，
+// 拦截的 CONNECT 隧道里 upstream 是隧道的目标，pool 是 TLS 连接池
+type proxyConn struct {
+	p    *Proxy
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+
+	upstream string
+	pool     *ConnPool
+	auth     Authenticator
+}
+
+// forward 转发一个请求并把响应写回客户端，返回false表示客户端连接不能继续使用
+func (pc *proxyConn) forward(req *Request, resp *Response) bool {
+	p := pc.p
+	clientClose := req.ShouldClose()
+	http10 := !req.Header.ProtoAtLeast(1, 1)
+	upgrade := req.IsUpgrade()
+
+	addr := pc.upstream
+	if addr == "" {
+		var err error
+		if addr, err = requestTarget(req); err != nil {
+			writeErrorResponse(pc.bw, http.StatusBadRequest)
+			return false
+		}
+	}
+
+	// 100 Continue 由 proxy 在读取body时自己发送
+	if req.ExpectContinue() {
+		req.EnableAutoContinue(pc.bw)
+		req.Header.Del(bExpect)
+	}
+	removeHopHeaders(&req.Header.headerFields, upgrade)
+	req.Header.Proto = append(req.Header.Proto[:0], bHTTP11...)
+	if p.Director != nil {
+		p.Director(req)
+	}
+
+	uc, err := pc.roundTrip(addr, req, resp, http10)
+	if err != nil {
+		writeErrorResponse(pc.bw, http.StatusBadGateway)
+		return false
+	}
+
+	if resp.Header.StatusCode == http.StatusSwitchingProtocols {
+		if upgrade {
+			pc.switchProtocols(uc, resp)
+		} else {
+			writeErrorResponse(pc.bw, http.StatusBadGateway)
+		}
+		pc.release(uc, false)
+		return false
+	}
+
+	upstreamKeepAlive := resp.KeepAlive()
+	removeHopHeaders(&resp.Header.headerFields, false)
+	if p.Rewriter != nil {
+		p.Rewriter.RewriteResponse(req, resp)
+	}
+	resp.Header.Proto = append(resp.Header.Proto[:0], bHTTP11...)
+
+	body := resp.Body
+	if _, untilEOF := resp.Body.(*bufio.Reader); untilEOF {
+		clientClose = true
+	} else if http10 && resp.Body != http.NoBody && resp.Header.GetChunkedEncoding() {
+		// HTTP/1.0 客户端不认识 chunked，解码之后靠关闭连接结束body
+		body = resp.DecodedBody()
+		resp.Header.Del(bTransferEncoding)
+		clientClose = true
+	}
+	if clientClose {
+		resp.Header.Set(bConnection, bClose)
+	} else if http10 {
+		resp.Header.Set(bConnection, bKeepAlive)
+	}
+
+	_, err = resp.Header.WriteTo(pc.bw)
+	if err == nil {
+		_, err = io.Copy(pc.bw, body)
+	}
+	if err == nil {
+		err = pc.bw.Flush()
+	}
+
+	pc.release(uc, err == nil && upstreamKeepAlive)
+	return err == nil && !clientClose
+}
+
+// reply 不转发请求，直接回复 status，location 不为空时是重定向
+func (pc *proxyConn) reply(req *Request, status int, location string, clientClose bool) bool {
+	keepAlive := !clientClose && skipRequest(req, maxDiscardBodySize)
+	var err error
+	if location != "" {
+		err = writeShortResponse(pc.bw, status, keepAlive, bLocation, location)
+	} else {
+		err = writeShortResponse(pc.bw, status, keepAlive, nil)
+	}
+	return err == nil && keepAlive
+}
+
+// roundTrip 把请求发给 addr 并读取最终响应的header。复用的连接可能已被上游关闭，
+// 此时如果请求没有body（可以安全重放），换一个连接重试
+func (pc *proxyConn) roundTrip(addr string, req *Request, resp *Response, http10 bool) (uc *PoolConn, err error) {
+	// 1xx 中间响应转发给客户端（HTTP/1.0 客户端除外），100 Continue 已经由 proxy 自己处理
+	interim := func(resp *Response) (err error) {
+		if resp.Header.StatusCode != http.StatusContinue && !http10 {
+			if _, err = resp.Header.WriteTo(pc.bw); err == nil {
+				err = pc.bw.Flush()
+			}
+		}
+		return
+	}
+
+	for {
+		if uc, err = pc.pool.Get(addr); err != nil {
+			return
+		}
+		if !pc.p.tracker.TrackConn(uc, true) {
+			uc.Close()
+			return nil, http.ErrServerClosed
+		}
+
+		if err = uc.roundTrip(req, resp, interim); err == nil {
+			return
+		}
+		pc.release(uc, false)
+		if !uc.Reused() || req.Body != http.NoBody {
+			return
+		}
+	}
+}
+
+// switchProtocols 把 101 响应转发给客户端，之后在两端之间双向转发数据
+func (pc *proxyConn) switchProtocols(uc *PoolConn, resp *Response) {
+	if _, err := resp.Header.WriteTo(pc.bw); err != nil || pc.bw.Flush() != nil {
+		return
+	}
+	pc.conn.SetReadDeadline(time.Time{})
+
+	// 双方在握手之后立即发送的数据可能已经在 bufio.Reader 里
+	if n := pc.br.Buffered(); n > 0 {
+		if _, err := io.CopyN(uc.Conn, pc.br, int64(n)); err != nil {
+			return
+		}
+	}
+	if n := uc.Reader.Buffered(); n > 0 {
+		if _, err := io.CopyN(pc.conn, uc.Reader, int64(n)); err != nil {
+			return
+		}
+	}
+	splice(pc.conn, uc.Conn, pc.p.TunnelIdleTimeout, &TunnelStats{})
+}
+
+// release 把上游连接还给连接池，reuse 为false时关闭连接
+func (pc *proxyConn) release(uc *PoolConn, reuse bool) {
+	pc.p.tracker.TrackConn(uc, false)
+	if reuse {
+		pc.pool.Put(uc)
+	} else {
+		uc.Close()
+	}
+}
+
+// requestTarget 返回请求的目标 host:port，并把 absolute-form 的 RequestURI 改写成
+// origin-form，同时用其中的 authority 替换 Host（RFC 7230 5.4）
+func requestTarget(req *Request) (addr string, err error) {
+	h := req.Header
+	if i := indexColonSlashSlash(h.RequestURI); i != -1 {
+		if !bytes.EqualFold(h.RequestURI[:i], bHTTP) {
+			return "", errUnsupportedScheme
+		}
+		authority := h.RequestURI[i+len(colonSlashSlash):]
+		path := bSlash
+		if j := bytes.IndexAny(authority, "/?#"); j != -1 {
+			authority, path = authority[:j], authority[j:]
+		}
+		h.Set(bHost, authority)
+
+		if path[0] == '/' {
+			h.RequestURI = append(h.RequestURI[:0], path...)
+		} else {
+			h.RequestURI = append(append([]byte(nil), '/'), path...)
+		}
+	}
+
+	host, port, err := req.GetHostPort()
+	if err != nil || host == "" {
+		return "", &badStringError{"missing host in request", string(h.RequestURI)}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// removeHopHeaders 删除逐跳的header以及 Connection 里列出的header，
+// upgrade 为true时保留 Upgrade 并重新设置 Connection: Upgrade
+func removeHopHeaders(h *headerFields, upgrade bool) {
+	var listed [][]byte
+	h.VisitFor(bConnection, func(i int, value []byte) bool {
+		for _, token := range bytes.Split(value, []byte(",")) {
+			if token = bytes.TrimSpace(token); len(token) > 0 {
+				key := append([]byte(nil), token...)
+				normalizeHeaderKey(key)
+				listed = append(listed, key)
+			}
+		}
+		return true
+	})
+	for _, key := range listed {
+		if !upgrade || !bytes.Equal(key, bUpgrade) {
+			h.Del(key)
+		}
+	}
+
+	for _, key := range hopHeaders {
+		h.Del(key)
+	}
+	if upgrade {
+		h.Set(bConnection, bUpgrade)
+	} else {
+		h.Del(bUpgrade)
+	}
+}
@@ -0,0 +1,226 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startProxy(t *testing.T, p *Proxy) (addr string) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go p.Serve(ln)
+	return ln.Addr().String()
+}
+
+// startOrigin 启动一个回显请求信息的上游服务，conns 记录它接受的连接数
+func startOrigin() (s *httptest.Server, conns *int32) {
+	conns = new(int32)
+	s = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Hop", r.Header.Get("X-Hop")+r.Header.Get("Proxy-Connection"))
+		w.Header().Set("X-Forwarded-By", r.Header.Get("X-Forwarded-By"))
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte("a"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("b"))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.Host, r.URL.RequestURI(), body)
+	}))
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	s.Start()
+	return
+}
+
+func Test_Proxy_Forward(t *testing.T) {
+	origin, conns := startOrigin()
+	defer origin.Close()
+	host := origin.Listener.Addr().String()
+
+	pool := &ConnPool{}
+	defer pool.Close()
+	p := &Proxy{Pool: pool}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	assert.Nil(t, e)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	// absolute-form 改写成 origin-form，Host 使用其中的 authority，逐跳header被删除
+	fmt.Fprintf(conn, "GET http://%s/a?x=1 HTTP/1.1\r\nHost: wrong\r\nConnection: X-Hop\r\nX-Hop: 1\r\nProxy-Connection: keep-alive\r\n\r\n", host)
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "GET "+host+" /a?x=1 ", string(body))
+	assert.Equal(t, "", resp.Header.Get("X-Hop"))
+
+	fmt.Fprintf(conn, "POST http://%s/echo HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nping\r\n0\r\n\r\n", host)
+	resp, e = http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "POST "+host+" /echo ping", string(body))
+
+	fmt.Fprintf(conn, "GET http://%s/chunked HTTP/1.1\r\n\r\n", host)
+	resp, e = http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "ab", string(body))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	// 三个请求复用同一个上游连接
+	assert.Equal(t, int32(1), atomic.LoadInt32(conns))
+	assert.Equal(t, PoolStats{Dials: 1, Hits: 2, Idle: 1}, pool.Stats())
+
+	fmt.Fprintf(conn, "GET https://%s/ HTTP/1.1\r\n\r\n", host)
+	resp, e = http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_Proxy_Reverse(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+
+	p := &Proxy{
+		Upstream: origin.Listener.Addr().String(),
+		Director: func(req *Request) {
+			req.Header.Set([]byte("X-Forwarded-By"), []byte("http1"))
+		},
+	}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	// HTTP/1.0 客户端：chunked 响应被解码，以关闭连接结束
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+	conn.Write([]byte("GET /chunked HTTP/1.0\r\nHost: example.com\r\n\r\n"))
+	resp, e := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.Nil(t, e)
+	body, e := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, e)
+	assert.Equal(t, "ab", string(body))
+	assert.Nil(t, resp.TransferEncoding)
+	assert.Equal(t, "http1", resp.Header.Get("X-Forwarded-By"))
+
+	// 反向代理不处理 CONNECT
+	conn2, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn2.Close()
+	conn2.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\n\r\n"))
+	resp, e = http.ReadResponse(bufio.NewReader(conn2), &http.Request{Method: "CONNECT"})
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func Test_Proxy_Connect(t *testing.T) {
+	target, _ := net.Listen("tcp4", "127.0.0.1:0")
+	defer target.Close()
+	go func() {
+		conn, e := target.Accept()
+		if e == nil {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}
+	}()
+
+	p := &Proxy{}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\n\r\nping", target.Addr())
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	assert.Nil(t, e)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	b := make([]byte, 4)
+	_, e = io.ReadFull(br, b)
+	assert.Nil(t, e)
+	assert.Equal(t, "ping", string(b))
+}
+
+func Test_Proxy_Upgrade(t *testing.T) {
+	s, origin := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		conn, buffered, e := w.SwitchProtocols("echo")
+		assert.Nil(t, e)
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, io.MultiReader(bytes.NewReader(buffered), io.LimitReader(conn, int64(4-len(buffered)))))
+		}()
+	})
+	defer s.Close()
+
+	p := &Proxy{}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET http://%s/ HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nPING", origin)
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+	b := make([]byte, 4)
+	_, e = io.ReadFull(br, b)
+	assert.Nil(t, e)
+	assert.Equal(t, "PING", string(b))
+}
+
+func Test_Proxy_BadGateway(t *testing.T) {
+	ln, _ := net.Listen("tcp4", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := &Proxy{}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET http://%s/ HTTP/1.1\r\n\r\n", addr)
+	resp, e := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func Test_Proxy_ReadBodyTimeout(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+	host := origin.Listener.Addr().String()
+
+	p := &Proxy{ReadTimeout: time.Second, ReadBodyTimeout: 50 * time.Millisecond}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+
+	// 客户端只发送了一部分body，proxy 超时后关闭连接
+	fmt.Fprintf(conn, "POST http://%s/ HTTP/1.1\r\nHost: %s\r\nContent-Length: 10\r\n\r\n01", host, host)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, e = ioutil.ReadAll(conn)
+	assert.Nil(t, e, "proxy 应该在body超时后关闭连接")
+}
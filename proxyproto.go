@@ -0,0 +1,557 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNoProxyHeader is returned by ReadProxyHeader when the data does not
+// start with a PROXY protocol header. Nothing has been consumed then.
+var ErrNoProxyHeader = errors.New("http1: no PROXY protocol header")
+
+// TLV types of PROXY protocol v2.
+const (
+	PP2TypeALPN      = 0x01
+	PP2TypeAuthority = 0x02
+	PP2TypeCRC32C    = 0x03
+	PP2TypeNoop      = 0x04
+	PP2TypeUniqueID  = 0x05
+	PP2TypeSSL       = 0x20
+	PP2TypeNetNS     = 0x30
+
+	// sub-TLVs of PP2TypeSSL
+	PP2SubtypeSSLVersion = 0x21
+	PP2SubtypeSSLCN      = 0x22
+	PP2SubtypeSSLCipher  = 0x23
+	PP2SubtypeSSLSigAlg  = 0x24
+	PP2SubtypeSSLKeyAlg  = 0x25
+)
+
+// PP2_CLIENT_* bits of ProxySSL.Client.
+const (
+	PP2ClientSSL      = 0x01
+	PP2ClientCertConn = 0x02
+	PP2ClientCertSess = 0x04
+)
+
+const (
+	proxyV1MaxLen = 107 // 包括 CRLF（协议文档 2.1）
+
+	proxyV2Local = 0x20
+	proxyV2Proxy = 0x21
+
+	proxyAFUnspec = 0x00
+	proxyAFInet   = 0x10
+	proxyAFInet6  = 0x20
+	proxyAFUnix   = 0x30
+
+	proxyStream = 0x01
+	proxyDgram  = 0x02
+)
+
+// ProxyHeader is a HAProxy PROXY protocol header, see
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt.
+type ProxyHeader struct {
+	Version int // 1 or 2
+
+	// Local is set for the v2 LOCAL command and for "PROXY UNKNOWN": the
+	// connection was made by the proxy itself and the addresses are unset.
+	Local bool
+
+	// SrcAddr and DstAddr are *net.TCPAddr, *net.UDPAddr or *net.UnixAddr.
+	SrcAddr net.Addr
+	DstAddr net.Addr
+
+	TLVs []ProxyTLV // v2 only
+}
+
+// ProxyTLV is a type-length-value field of a v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxySSL is the content of the PP2TypeSSL TLV.
+type ProxySSL struct {
+	Client  byte   // PP2Client* bits
+	Verify  uint32 // 0 if the client certificate was verified
+	Version string
+	CN      string
+	Cipher  string
+	SigAlg  string
+	KeyAlg  string
+}
+
+// TLV returns the value of the first TLV of type typ.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UniqueID returns the PP2TypeUniqueID TLV, or nil.
+func (h *ProxyHeader) UniqueID() []byte {
+	id, _ := h.TLV(PP2TypeUniqueID)
+	return id
+}
+
+// SSL returns the PP2TypeSSL TLV.
+func (h *ProxyHeader) SSL() (ssl ProxySSL, ok bool) {
+	v, ok := h.TLV(PP2TypeSSL)
+	if !ok || len(v) < 5 {
+		return ssl, false
+	}
+	ssl.Client = v[0]
+	ssl.Verify = binary.BigEndian.Uint32(v[1:5])
+
+	tlvs, err := parseProxyTLVs(v[5:])
+	if err != nil {
+		return ssl, false
+	}
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case PP2SubtypeSSLVersion:
+			ssl.Version = string(tlv.Value)
+		case PP2SubtypeSSLCN:
+			ssl.CN = string(tlv.Value)
+		case PP2SubtypeSSLCipher:
+			ssl.Cipher = string(tlv.Value)
+		case PP2SubtypeSSLSigAlg:
+			ssl.SigAlg = string(tlv.Value)
+		case PP2SubtypeSSLKeyAlg:
+			ssl.KeyAlg = string(tlv.Value)
+		}
+	}
+	return ssl, true
+}
+
+// ReadProxyHeader reads a v1 or v2 PROXY protocol header from br. If br does
+// not start with one, it returns ErrNoProxyHeader without consuming anything.
+// Read errors, e.g. a timeout before the signature is complete, are returned
+// as they are.
+func ReadProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	b, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case 'P':
+		if err = peekSignature(br, proxyV1Prefix); err != nil {
+			return nil, err
+		}
+		return readProxyV1(br)
+	case '\r':
+		if err = peekSignature(br, proxyV2Sig); err != nil {
+			return nil, err
+		}
+		return readProxyV2(br)
+	}
+	return nil, ErrNoProxyHeader
+}
+
+// peekSignature 检查 br 是否以 sig 开头。已经读到的数据和 sig 不同时返回 ErrNoProxyHeader，
+// 否则返回读取的错误
+func peekSignature(br *bufio.Reader, sig []byte) error {
+	b, err := br.Peek(len(sig))
+	if !bytes.HasPrefix(sig, b) {
+		return ErrNoProxyHeader
+	}
+	return err
+}
+
+// readProxyV1 解析 "PROXY TCP4 src dst sport dport\r\n"
+func readProxyV1(br *bufio.Reader) (*ProxyHeader, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull && len(line) > proxyV1MaxLen {
+			err = &badStringError{"PROXY header too long", string(line[:proxyV1MaxLen])}
+		}
+		return nil, err
+	}
+	if len(line) > proxyV1MaxLen || len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, &badStringError{"malformed PROXY header", string(line)}
+	}
+
+	h := &ProxyHeader{Version: 1}
+	fields := bytes.Split(line[len(proxyV1Prefix):len(line)-2], bSpace)
+	switch string(fields[0]) {
+	case "UNKNOWN":
+		// 之后的内容需要忽略
+		h.Local = true
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, &badStringError{"unsupported PROXY protocol", string(fields[0])}
+	}
+	if len(fields) != 5 {
+		return nil, &badStringError{"malformed PROXY header", string(line)}
+	}
+
+	v4 := fields[0][3] == '4'
+	var src, dst net.TCPAddr
+	if src.IP, err = parseProxyIP(fields[1], v4); err == nil {
+		if dst.IP, err = parseProxyIP(fields[2], v4); err == nil {
+			if src.Port, err = parseProxyPort(fields[3]); err == nil {
+				dst.Port, err = parseProxyPort(fields[4])
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.SrcAddr, h.DstAddr = &src, &dst
+	return h, nil
+}
+
+func parseProxyIP(b []byte, v4 bool) (net.IP, error) {
+	ip := net.ParseIP(string(b))
+	if ip == nil || v4 != (bytes.IndexByte(b, ':') == -1) {
+		return nil, &badStringError{"malformed address in PROXY header", string(b)}
+	}
+	return ip, nil
+}
+
+func parseProxyPort(b []byte) (int, error) {
+	// 不允许前导0
+	port, err := strconv.Atoi(string(b))
+	if err != nil || port < 0 || port > 65535 || len(b) > 1 && b[0] == '0' {
+		return 0, &badStringError{"malformed port in PROXY header", string(b)}
+	}
+	return port, nil
+}
+
+// readProxyV2 解析二进制的头：12字节签名，版本和命令，地址族和协议，2字节长度，地址，TLV
+func readProxyV2(br *bufio.Reader) (*ProxyHeader, error) {
+	b, err := br.Peek(16)
+	if err != nil {
+		return nil, err
+	}
+	ver, fam := b[12], b[13]
+	n := int(binary.BigEndian.Uint16(b[14:16]))
+	if ver != proxyV2Local && ver != proxyV2Proxy {
+		return nil, &badStringError{"unsupported PROXY version/command", string(b[12:13])}
+	}
+	br.Discard(16)
+
+	// 长度最大 65535，可能超过 bufio.Reader 的缓冲区
+	b = make([]byte, n)
+	if _, err = io.ReadFull(br, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	h := &ProxyHeader{Version: 2, Local: ver == proxyV2Local}
+	var addrLen int
+	switch fam & 0xf0 {
+	case proxyAFInet:
+		addrLen = 12
+	case proxyAFInet6:
+		addrLen = 36
+	case proxyAFUnix:
+		addrLen = 216
+	}
+	if len(b) < addrLen {
+		return nil, &badStringError{"short address in PROXY header", string(b)}
+	}
+	if !h.Local && addrLen > 0 {
+		if h.SrcAddr, h.DstAddr, err = parseProxyV2Addrs(fam, b[:addrLen]); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.TLVs, err = parseProxyTLVs(b[addrLen:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseProxyV2Addrs(fam byte, b []byte) (src, dst net.Addr, err error) {
+	if fam&0xf0 == proxyAFUnix {
+		unixNet := "unix"
+		if fam&0x0f == proxyDgram {
+			unixNet = "unixgram"
+		}
+		return &net.UnixAddr{Net: unixNet, Name: cString(b[:108])},
+			&net.UnixAddr{Net: unixNet, Name: cString(b[108:])}, nil
+	}
+
+	ipLen := 4
+	if fam&0xf0 == proxyAFInet6 {
+		ipLen = 16
+	}
+	srcIP := net.IP(append([]byte(nil), b[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), b[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(b[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(b[2*ipLen+2:]))
+
+	switch fam & 0x0f {
+	case proxyStream:
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+	case proxyDgram:
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	}
+	return nil, nil, &badStringError{"unsupported PROXY transport", string(fam)}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseProxyTLVs(b []byte) (tlvs []ProxyTLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, &badStringError{"malformed TLV in PROXY header", string(b)}
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, &badStringError{"malformed TLV in PROXY header", string(b)}
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return
+}
+
+// AppendTo appends the header in its wire format to dst. Version 1 headers
+// only carry TCP addresses, anything else is written as "PROXY UNKNOWN".
+func (h *ProxyHeader) AppendTo(dst []byte) []byte {
+	if h.Version == 1 {
+		return h.appendV1(dst)
+	}
+	return h.appendV2(dst)
+}
+
+// WriteTo writes the header to w, e.g. at the start of an upstream
+// connection.
+func (h *ProxyHeader) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(h.AppendTo(nil))
+	return int64(n), err
+}
+
+func (h *ProxyHeader) appendV1(dst []byte) []byte {
+	dst = append(dst, proxyV1Prefix...)
+	src, ok1 := h.SrcAddr.(*net.TCPAddr)
+	dstAddr, ok2 := h.DstAddr.(*net.TCPAddr)
+	v4 := ok1 && src.IP.To4() != nil
+	if h.Local || !ok1 || !ok2 || v4 != (dstAddr.IP.To4() != nil) {
+		return append(dst, "UNKNOWN\r\n"...)
+	}
+
+	if v4 {
+		dst = append(dst, "TCP4 "...)
+	} else {
+		dst = append(dst, "TCP6 "...)
+	}
+	dst = append(dst, src.IP.String()...)
+	dst = append(dst, ' ')
+	dst = append(dst, dstAddr.IP.String()...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(src.Port), 10)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(dstAddr.Port), 10)
+	return append(dst, CRLF...)
+}
+
+func (h *ProxyHeader) appendV2(dst []byte) []byte {
+	dst = append(dst, proxyV2Sig...)
+	fam, addrs := byte(proxyAFUnspec), []byte(nil)
+	if h.Local {
+		dst = append(dst, proxyV2Local)
+	} else {
+		dst = append(dst, proxyV2Proxy)
+		fam, addrs = proxyV2Addrs(h.SrcAddr, h.DstAddr)
+	}
+	dst = append(dst, fam)
+
+	n := len(addrs)
+	for _, tlv := range h.TLVs {
+		n += 3 + len(tlv.Value)
+	}
+	dst = append(dst, byte(n>>8), byte(n))
+	dst = append(dst, addrs...)
+	for _, tlv := range h.TLVs {
+		dst = append(dst, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		dst = append(dst, tlv.Value...)
+	}
+	return dst
+}
+
+// proxyV2Addrs 返回地址族和编码后的地址，不支持的地址返回 AF_UNSPEC
+func proxyV2Addrs(src, dst net.Addr) (fam byte, b []byte) {
+	var srcIP, dstIP net.IP
+	var srcPort, dstPort int
+	switch s := src.(type) {
+	case *net.TCPAddr:
+		d, ok := dst.(*net.TCPAddr)
+		if !ok {
+			return proxyAFUnspec, nil
+		}
+		fam, srcIP, dstIP, srcPort, dstPort = proxyStream, s.IP, d.IP, s.Port, d.Port
+	case *net.UDPAddr:
+		d, ok := dst.(*net.UDPAddr)
+		if !ok {
+			return proxyAFUnspec, nil
+		}
+		fam, srcIP, dstIP, srcPort, dstPort = proxyDgram, s.IP, d.IP, s.Port, d.Port
+	case *net.UnixAddr:
+		d, ok := dst.(*net.UnixAddr)
+		if !ok || len(s.Name) > 108 || len(d.Name) > 108 {
+			return proxyAFUnspec, nil
+		}
+		fam = proxyAFUnix | proxyStream
+		if s.Net == "unixgram" {
+			fam = proxyAFUnix | proxyDgram
+		}
+		b = make([]byte, 216)
+		copy(b, s.Name)
+		copy(b[108:], d.Name)
+		return fam, b
+	default:
+		return proxyAFUnspec, nil
+	}
+
+	if s4, d4 := srcIP.To4(), dstIP.To4(); s4 != nil && d4 != nil {
+		fam |= proxyAFInet
+		b = append(append(b, s4...), d4...)
+	} else if srcIP.To16() != nil && dstIP.To16() != nil {
+		fam |= proxyAFInet6
+		b = append(append(b, srcIP.To16()...), dstIP.To16()...)
+	} else {
+		return proxyAFUnspec, nil
+	}
+	return fam, append(b, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort))
+}
+
+// ProxyListener wraps a listener whose clients (load balancers) send a PROXY
+// protocol header first. Accepted connections are *ProxyConn; the header is
+// read on the first Read, RemoteAddr or LocalAddr so Accept never blocks.
+type ProxyListener struct {
+	net.Listener
+
+	// Trusted are the networks of the peers allowed to send a header. If
+	// set, connections from other peers are returned as they are, without
+	// looking for a header, so that clients cannot forge their address.
+	Trusted []*net.IPNet
+
+	// ReadTimeout limits reading the header; 0 means no limit.
+	ReadTimeout time.Duration
+
+	// Optional accepts connections without a header, e.g. health checks
+	// made directly to the server. Otherwise their first Read fails with
+	// ErrNoProxyHeader.
+	Optional bool
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	c := NewProxyConn(conn)
+	c.timeout, c.optional = l.ReadTimeout, l.Optional
+	return c, nil
+}
+
+func (l *ProxyListener) trusted(addr net.Addr) bool {
+	if l.Trusted == nil {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyConn is a connection starting with a PROXY protocol header. Its
+// RemoteAddr and LocalAddr are the addresses in the header.
+type ProxyConn struct {
+	net.Conn
+
+	br       *bufio.Reader
+	once     sync.Once
+	header   *ProxyHeader
+	err      error
+	timeout  time.Duration
+	optional bool
+}
+
+// NewProxyConn wraps conn, which must start with a PROXY header. It can be
+// used with Mux: m.Handle(ProtoPROXY, func(c net.Conn) { m.ServeConn(NewProxyConn(c)) }).
+func NewProxyConn(conn net.Conn) *ProxyConn {
+	return &ProxyConn{Conn: conn}
+}
+
+// ProxyHeader reads the header if not done yet and returns it. With
+// ProxyListener.Optional, a connection without header returns nil, nil.
+func (c *ProxyConn) ProxyHeader() (*ProxyHeader, error) {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.br = bufio.NewReader(c.Conn)
+		c.header, c.err = ReadProxyHeader(c.br)
+		if c.err == ErrNoProxyHeader && c.optional {
+			c.err = nil
+		}
+	})
+	return c.header, c.err
+}
+
+func (c *ProxyConn) Read(p []byte) (int, error) {
+	if _, err := c.ProxyHeader(); err != nil {
+		return 0, err
+	}
+	// 读完缓冲之后直接读连接
+	if c.br.Buffered() > 0 {
+		return c.br.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the source address of the header, or the address of
+// the peer if there is none.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if h, _ := c.ProxyHeader(); h != nil && h.SrcAddr != nil {
+		return h.SrcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header, or the local
+// address if there is none.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if h, _ := c.ProxyHeader(); h != nil && h.DstAddr != nil {
+		return h.DstAddr
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,198 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ReadProxyHeader_V1(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	h, e := ReadProxyHeader(br)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 1, h.Version)
+		assert.Equal(t, "192.168.0.1:56324", h.SrcAddr.String())
+		assert.Equal(t, "192.168.0.11:443", h.DstAddr.String())
+	}
+	req, e := ReadRequest(br)
+	if assert.Nil(t, e) {
+		assert.Equal(t, "/", string(req.Header.RequestURI))
+	}
+
+	h, e = ReadProxyHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 ::1 ::2 1 2\r\n")))
+	if assert.Nil(t, e) {
+		assert.Equal(t, "[::1]:1", h.SrcAddr.String())
+		assert.Equal(t, []byte("PROXY TCP6 ::1 ::2 1 2\r\n"), h.AppendTo(nil))
+	}
+
+	h, e = ReadProxyHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN ff:: ff:: 1 2\r\n")))
+	if assert.Nil(t, e) {
+		assert.True(t, h.Local)
+		assert.Nil(t, h.SrcAddr)
+	}
+
+	for _, s := range []string{
+		"PROXY TCP4 ::1 ::2 1 2\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1 70000\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1\r\n",
+		"PROXY UDP4 1.1.1.1 2.2.2.2 1 2\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1 2\n",
+	} {
+		_, e = ReadProxyHeader(bufio.NewReader(strings.NewReader(s)))
+		assert.NotNil(t, e, s)
+	}
+
+	br = bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	_, e = ReadProxyHeader(br)
+	assert.Equal(t, ErrNoProxyHeader, e)
+	assert.Equal(t, 18, br.Buffered(), "没有PROXY header时不消耗数据")
+
+	// 读取签名时出错返回原来的错误
+	_, e = ReadProxyHeader(bufio.NewReader(strings.NewReader("PROX")))
+	assert.Equal(t, io.EOF, e)
+	left, right := net.Pipe()
+	defer left.Close()
+	go left.Write([]byte("PROX"))
+	right.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, e = ReadProxyHeader(bufio.NewReader(right))
+	if ne, ok := e.(net.Error); assert.True(t, ok, "%v", e) {
+		assert.True(t, ne.Timeout())
+	}
+}
+
+func Test_ReadProxyHeader_V2(t *testing.T) {
+	ssl := []byte{PP2ClientSSL, 0, 0, 0, 0}
+	ssl = append(ssl, PP2SubtypeSSLVersion, 0, 7)
+	ssl = append(ssl, "TLSv1.3"...)
+	ssl = append(ssl, PP2SubtypeSSLCN, 0, 7)
+	ssl = append(ssl, "example"...)
+
+	h := &ProxyHeader{
+		Version: 2,
+		SrcAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+		DstAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80},
+		TLVs: []ProxyTLV{
+			{Type: PP2TypeUniqueID, Value: []byte("req-1")},
+			{Type: PP2TypeSSL, Value: ssl},
+		},
+	}
+	b := h.AppendTo(nil)
+	assert.Equal(t, 16+12+8+3+len(ssl), len(b))
+
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("rest")))
+	h2, e := ReadProxyHeader(br)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 2, h2.Version)
+		assert.False(t, h2.Local)
+		assert.Equal(t, "10.0.0.1:1234", h2.SrcAddr.String())
+		assert.Equal(t, "10.0.0.2:80", h2.DstAddr.String())
+		assert.Equal(t, []byte("req-1"), h2.UniqueID())
+
+		s, ok := h2.SSL()
+		assert.True(t, ok)
+		assert.Equal(t, ProxySSL{Client: PP2ClientSSL, Version: "TLSv1.3", CN: "example"}, s)
+	}
+	rest, _ := ioutil.ReadAll(br)
+	assert.Equal(t, "rest", string(rest))
+
+	h = &ProxyHeader{
+		Version: 2,
+		SrcAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53},
+		DstAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53},
+	}
+	h2, e = ReadProxyHeader(bufio.NewReader(bytes.NewReader(h.AppendTo(nil))))
+	if assert.Nil(t, e) {
+		assert.Equal(t, h.SrcAddr, h2.SrcAddr)
+		assert.Equal(t, h.DstAddr, h2.DstAddr)
+	}
+
+	h2, e = ReadProxyHeader(bufio.NewReader(bytes.NewReader((&ProxyHeader{Version: 2, Local: true}).AppendTo(nil))))
+	if assert.Nil(t, e) {
+		assert.True(t, h2.Local)
+		assert.Nil(t, h2.SrcAddr)
+	}
+
+	// 长度超出数据
+	b = (&ProxyHeader{Version: 2, SrcAddr: h.SrcAddr, DstAddr: h.DstAddr}).AppendTo(nil)
+	_, e = ReadProxyHeader(bufio.NewReader(bytes.NewReader(b[:len(b)-1])))
+	assert.Equal(t, io.ErrUnexpectedEOF, e)
+}
+
+func Test_ProxyListener(t *testing.T) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	remote := make(chan string, 2)
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+	})}
+	go s.Serve(&ProxyListener{Listener: ln, Optional: true})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", ln.Addr().String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	h := &ProxyHeader{
+		Version: 1,
+		SrcAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000},
+		DstAddr: conn.RemoteAddr(),
+	}
+	h.WriteTo(conn)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, "203.0.113.7:4000", <-remote)
+
+	// Optional 时可以没有 PROXY header
+	resp, e := http.Get("http://" + ln.Addr().String() + "/")
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.Equal(t, "127.0.0.1", strings.Split(<-remote, ":")[0])
+	}
+}
+
+func Test_ProxyListener_Trusted(t *testing.T) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	remote := make(chan string, 1)
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+	})}
+	go s.Serve(&ProxyListener{Listener: ln, Trusted: []*net.IPNet{trusted}})
+	defer s.Close()
+
+	// 不受信任的来源不解析 PROXY header，它被当作请求
+	conn, e := net.Dial("tcp4", ln.Addr().String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	h := &ProxyHeader{
+		Version: 1,
+		SrcAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000},
+		DstAddr: conn.RemoteAddr(),
+	}
+	h.WriteTo(conn)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, e := http.ReadResponse(bufio.NewReader(conn), nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp, e = http.Get("http://" + ln.Addr().String() + "/")
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.Equal(t, "127.0.0.1", strings.Split(<-remote, ":")[0])
+	}
+}
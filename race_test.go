@@ -0,0 +1,8 @@
+// +build race
+
+package http1
+
+func init() {
+	// race detector 会随机丢弃 sync.Pool 里的对象
+	raceEnabled = true
+}
@@ -0,0 +1,93 @@
+package http1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrReplayDisabled = errors.New("http1: replay is not enabled for the request")
+	ErrReplayOverflow = errors.New("http1: consumed body exceeds the replay buffer")
+)
+
+// EnableReplay makes Read keep the request line and headers exactly as
+// received, and copy up to maxBody bytes of the body read through m.Body,
+// so that RestoreConn can hand the connection to another handler without
+// losing anything. It stays in effect across Read calls until the request
+// is released.
+func (m *Request) EnableReplay(maxBody int) {
+	m.Header.keepRaw = true
+	m.replay.enabled = true
+	m.replay.max = maxBody
+}
+
+func (m *Request) disableReplay() {
+	m.Header.keepRaw = false
+	m.Header.raw = m.Header.raw[:0]
+	m.replay.enabled = false
+	m.replay.reset(nil)
+}
+
+// RawHeader returns the request line and headers as received, including
+// the empty line, or nil if EnableReplay has not been called.
+func (m *Request) RawHeader() []byte {
+	if !m.Header.keepRaw {
+		return nil
+	}
+	return m.Header.raw
+}
+
+// ReplayBytes returns RawHeader followed by the body bytes consumed so far,
+// as they were on the wire. It fails with ErrReplayOverflow once more than
+// maxBody bytes of body have been read.
+func (m *Request) ReplayBytes() ([]byte, error) {
+	if !m.replay.enabled {
+		return nil, ErrReplayDisabled
+	}
+	if m.replay.overflow {
+		return nil, ErrReplayOverflow
+	}
+	b := make([]byte, 0, len(m.Header.raw)+len(m.replay.buf))
+	b = append(b, m.Header.raw...)
+	return append(b, m.replay.buf...), nil
+}
+
+// teeBody 开启 replay 时让body经过 replayReader，记录读取过的原始数据
+func (m *Request) teeBody() {
+	if !m.replay.enabled {
+		return
+	}
+	m.replay.reset(m.Body)
+	if m.Body != nil && m.Body != http.NoBody {
+		m.Body = &m.replay
+	}
+}
+
+// replayReader 把读到的数据保存到 buf，超过 max 之后不再保存
+type replayReader struct {
+	r        io.Reader
+	enabled  bool
+	max      int
+	buf      []byte
+	overflow bool
+}
+
+func (rr *replayReader) reset(r io.Reader) {
+	rr.r = r
+	rr.buf = rr.buf[:0]
+	rr.overflow = false
+}
+
+func (rr *replayReader) Read(b []byte) (n int, err error) {
+	n, err = rr.r.Read(b)
+	if n > 0 && !rr.overflow {
+		if len(rr.buf)+n > rr.max {
+			rr.overflow = true
+			rr.buf = rr.buf[:0]
+		} else {
+			rr.buf = append(rr.buf, b[:n]...)
+		}
+	}
+	return
+}
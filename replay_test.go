@@ -0,0 +1,96 @@
+package http1
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_RestoreConn_Replay(t *testing.T) {
+	raw := strings.Join([]string{
+		"POST /upload HTTP/1.1",
+		"host: a.com",
+		"transfer-ENCODING: chunked",
+		"",
+		"5",
+		"hello",
+		"6",
+		" world",
+		"0",
+		"",
+		"GET /next HTTP/1.1",
+		"", "",
+	}, "\r\n")
+
+	left, right := net.Pipe()
+	go func() {
+		left.Write([]byte(raw))
+		left.Close()
+	}()
+
+	br := bufio.NewReader(right)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.EnableReplay(1024)
+	assert.Nil(t, req.Read(br))
+	assert.Equal(t, "POST /upload HTTP/1.1\r\nhost: a.com\r\ntransfer-ENCODING: chunked\r\n\r\n", string(req.RawHeader()))
+
+	// 读掉一部分body之后交给别的handler，仍然能得到完整的原始数据
+	b := make([]byte, 7)
+	_, e := io.ReadFull(req.Body, b)
+	assert.Nil(t, e)
+
+	conn := RestoreConn(right, br, req)
+	all, e := ioutil.ReadAll(conn)
+	assert.Nil(t, e)
+	assert.Equal(t, raw, string(all))
+}
+
+func Test_ReplayConn_Overflow(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789"))
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.EnableReplay(4)
+	assert.Nil(t, req.Read(br))
+	io.ReadFull(req.Body, make([]byte, 5))
+
+	// 读过的body超过了 replay 的缓冲，不能只重放header
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+	conn, e := ReplayConn(right, br, req)
+	assert.Nil(t, conn)
+	assert.Equal(t, ErrReplayOverflow, e)
+
+	_, e = RestoreConn(right, br, req).Read(make([]byte, 1))
+	assert.Equal(t, ErrReplayOverflow, e)
+}
+
+func Test_Request_ReplayBytes(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789"))
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+
+	req.EnableReplay(4)
+	assert.Nil(t, req.Read(br))
+	io.ReadFull(req.Body, make([]byte, 4))
+	b, e := req.ReplayBytes()
+	assert.Nil(t, e)
+	assert.Equal(t, "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123", string(b))
+
+	io.ReadFull(req.Body, make([]byte, 1))
+	_, e = req.ReplayBytes()
+	assert.Equal(t, ErrReplayOverflow, e)
+
+	// 没有开启时不保存原始数据
+	req2, e := ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	assert.Nil(t, e)
+	assert.Nil(t, req2.RawHeader())
+	_, e = req2.ReplayBytes()
+	assert.Equal(t, ErrReplayDisabled, e)
+	ReleaseRequest(req2)
+}
@@ -3,9 +3,13 @@ package http1
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 )
 
@@ -16,11 +20,27 @@ type badStringError struct {
 
 func (e *badStringError) Error() string { return fmt.Sprintf("%s %q", e.what, e.str) }
 
+var (
+	ErrBodyTooLarge  = errors.New("http1: body too large")
+	ErrUnboundedBody = errors.New("http1: body is delimited by connection close")
+	ErrShortBody     = errors.New("http1: body is shorter than Content-Length")
+
+	errContinueNotSent = errors.New("http1: client is waiting for 100 Continue")
+)
+
 var requestPool sync.Pool
 
 type Request struct {
 	Header *RequestHeader
 	Body   io.Reader
+
+	bodyConn net.Conn // body 所在的连接，见 SetBodyConn
+	replay   replayReader
+
+	decoded  *dechunkedReader // 见 DecodedBody
+	postArgs Args
+	postErr  error
+	postRead bool
 }
 
 func AcquireRequest() (r *Request) {
@@ -30,6 +50,8 @@ func AcquireRequest() (r *Request) {
 		r = x.(*Request)
 		r.Header.reset()
 		r.resetBody()
+		r.bodyConn = nil
+		r.disableReplay()
 	}
 	return
 }
@@ -40,8 +62,10 @@ func ReleaseRequest(r *Request) {
 
 func (m *Request) Read(r *bufio.Reader) (err error) {
 	m.Header.reset()
+	m.Header.raw = m.Header.raw[:0]
 	if err = m.Header.Read(r); err == nil {
 		m.readBody(r)
+		m.teeBody()
 	}
 
 	return
@@ -49,7 +73,7 @@ func (m *Request) Read(r *bufio.Reader) (err error) {
 
 func (m *Request) resetBody() {
 	if m.Body != nil {
-		switch r := m.Body.(type) {
+		switch r := m.rawBody().(type) {
 		case *chunkedReader:
 			releaseChunkedReader(r)
 		case *io.LimitedReader:
@@ -58,6 +82,10 @@ func (m *Request) resetBody() {
 
 		m.Body = nil
 	}
+	m.decoded = nil
+	m.postArgs.Reset()
+	m.postErr = nil
+	m.postRead = false
 }
 
 func (m *Request) readBody(br *bufio.Reader) {
@@ -69,27 +97,221 @@ func (m *Request) readBody(br *bufio.Reader) {
 	} else if contentLength := m.Header.GetContentLength(); contentLength > 0 {
 		m.Body = acquireLimitedReader(br, int64(contentLength))
 
-	} else if bytes.Equal(m.Header.Method, bGET) || bytes.Equal(m.Header.Method, bHEAD) {
-		m.Body = http.NoBody
+	} else if bytes.Equal(m.Header.Method, bCONNECT) {
+		m.Body = br // 隧道的数据，读到连接关闭为止
 	} else {
-		// CONNECT / Connection:close / ...
-		m.Body = br // read until EOF
+		// 没有 Content-Length 和 chunked 的请求没有body（RFC 7230 3.3.3）
+		m.Body = http.NoBody
 	}
 }
 
-func (m *Request) WriteTo(w io.Writer) (n int, err error) {
-	var written int
-	written, err = m.Header.WriteTo(w)
-	n += written
+// KeepAlive reports whether the connection may be reused after this request,
+// following RFC 7230 section 6.3. CONNECT, whose body is the tunnel up to
+// the end of the connection, always ends it.
+func (m *Request) KeepAlive() bool {
+	if _, untilEOF := m.rawBody().(*bufio.Reader); untilEOF {
+		return false
+	}
+	if m.Header.HasToken(bConnection, bClose) {
+		return false
+	}
+	if m.Header.ProtoAtLeast(1, 1) {
+		return true
+	}
+	return m.Header.HasToken(bConnection, bKeepAlive)
+}
+
+func (m *Request) ShouldClose() bool {
+	return !m.KeepAlive()
+}
+
+// DiscardBody reads and discards what is left of the body, so the next
+// Read on the same bufio.Reader starts at a request boundary. It gives up
+// with ErrBodyTooLarge after limit bytes, and returns ErrUnboundedBody for
+// bodies that only end with the connection. If the client is still waiting
+// for "100 Continue" it is not sent and an error is returned, since it is
+// unknown whether the body will follow.
+func (m *Request) DiscardBody(limit int64) error {
+	if cr, ok := m.Body.(*continueReader); ok && !cr.sent {
+		return errContinueNotSent
+	}
+	switch m.rawBody().(type) {
+	case nil:
+		return nil
+	case *bufio.Reader:
+		return ErrUnboundedBody
+	}
+
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(m.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// WriteTo writes the request with a body framing that matches the header,
+// which is updated before it is written:
+//   - a chunked or close-delimited body obtained from Read or FromStdRequest
+//     is written as it is;
+//   - a body of known size (http.NoBody or a reader with a Len() int method,
+//     e.g. bytes.Reader, strings.Reader) sets Content-Length;
+//   - otherwise with Content-Length exactly that many bytes are written, and
+//     a shorter body fails with ErrShortBody;
+//   - any other body is sent chunked.
+//
+// When w is a *net.TCPConn the header is sent with writev without building
+// Bytes(), see SetBodyConn for the body.
+func (m *Request) WriteTo(w io.Writer) (n int64, err error) {
+	body, contentLength := m.frameBody()
+	if conn, ok := w.(*net.TCPConn); ok {
+		return m.writeToTCPConn(conn, body, contentLength)
+	}
+
+	n, err = m.Header.WriteTo(w)
+	if err == nil && body != nil {
+		var written int64
+		if contentLength >= 0 {
+			written, err = io.CopyN(w, body, contentLength)
+			if err == io.EOF {
+				err = ErrShortBody
+			}
+		} else {
+			written, err = io.Copy(w, body)
+		}
+		n += written
+	}
+	return
+}
+
+// SetBodyConn tells m that its body is read from conn, through the
+// bufio.Reader passed to Read. WriteTo to a *net.TCPConn then sends the
+// bytes of a Content-Length body still in the bufio.Reader together with
+// the header, and lets the kernel splice the rest from conn. It stays set
+// across Read calls until the request is released.
+func (m *Request) SetBodyConn(conn net.Conn) {
+	m.bodyConn = conn
+}
+
+// writeToTCPConn 用 writev 一次写出header和已经缓冲的body，剩下的body尽量用 splice
+func (m *Request) writeToTCPConn(conn *net.TCPConn, body io.Reader, contentLength int64) (n int64, err error) {
+	bufs := acquireBuffers()
+	defer releaseBuffers(bufs)
+	*bufs = m.Header.appendBuffers(*bufs)
 
-	if err == nil && m.Body != nil {
-		var written64 int64
-		written64, err = io.Copy(w, m.Body)
-		n += int(written64)
+	// Content-Length 的body已经在 bufio.Reader 里的部分和header一起写出
+	lr, br := m.bufferedBody(body)
+	var buffered []byte
+	if br != nil && contentLength > 0 {
+		k := int64(br.Buffered())
+		if k > lr.N {
+			k = lr.N
+		}
+		if k > contentLength {
+			k = contentLength
+		}
+		buffered, _ = br.Peek(int(k))
+		*bufs = append(*bufs, buffered)
+	}
+
+	v := *bufs
+	if n, err = v.WriteTo(conn); err != nil || body == nil {
+		return
+	}
+	var written int64
+	if contentLength < 0 {
+		written, err = io.Copy(conn, body)
+		n += written
+		return
+	}
+
+	if len(buffered) > 0 {
+		mustDiscard(br, len(buffered))
+		lr.N -= int64(len(buffered))
+	}
+	rest := contentLength - int64(len(buffered))
+	if src, ok := m.bodyConn.(*net.TCPConn); ok && br != nil && br.Buffered() == 0 && lr.N >= rest {
+		// bufio.Reader 已经空了，剩下的body直接从连接读，TCPConn.ReadFrom 会用 splice
+		written, err = conn.ReadFrom(&io.LimitedReader{R: src, N: rest})
+		lr.N -= written
+	} else {
+		written, err = io.CopyN(conn, body, rest)
+	}
+	n += written
+	if err == nil && written < rest || err == io.EOF {
+		err = ErrShortBody
 	}
 	return
 }
 
+// bufferedBody 返回 Content-Length body 底层的 LimitedReader 和 bufio.Reader，
+// 只有可以绕过 body 直接读取时（没有等待发送的 100 Continue）才返回
+func (m *Request) bufferedBody(body io.Reader) (*io.LimitedReader, *bufio.Reader) {
+	if body != m.rawBody() && !m.ContinueSent() {
+		return nil, nil
+	}
+	lr, ok := m.rawBody().(*io.LimitedReader)
+	if !ok {
+		return nil, nil
+	}
+	br, ok := lr.R.(*bufio.Reader)
+	if !ok {
+		return nil, nil
+	}
+	return lr, br
+}
+
+type lener interface {
+	Len() int
+}
+
+// frameBody 按body的类型调整 Content-Length 和 Transfer-Encoding，
+// 返回需要写的body及其长度（-1表示写到EOF）
+func (m *Request) frameBody() (body io.Reader, contentLength int64) {
+	h := m.Header
+	if bytes.Equal(h.Method, bCONNECT) {
+		// CONNECT 之后的数据属于隧道，不是body
+		return nil, -1
+	}
+
+	switch m.rawBody().(type) {
+	case *chunkedReader, *chunkedEncoder, *bufio.Reader:
+		// 已经是chunked编码的原始数据，或者读到连接关闭为止的body
+		return m.Body, -1
+	}
+
+	size := int64(-1)
+	if m.Body == nil || m.Body == http.NoBody {
+		size = 0
+	} else if l, ok := m.Body.(lener); ok {
+		size = int64(l.Len())
+	}
+
+	chunked := h.GetChunkedEncoding()
+	if size >= 0 {
+		if size > 0 || chunked || h.GetContentLength() >= 0 {
+			var b [20]byte
+			h.Del(bTransferEncoding)
+			h.Set(bContentLength, strconv.AppendInt(b[:0], size, 10))
+		}
+		if size == 0 {
+			return nil, 0
+		}
+		return m.Body, size
+	}
+
+	if cl := h.GetContentLength(); cl >= 0 && !chunked {
+		return m.Body, int64(cl)
+	}
+	h.Del(bContentLength)
+	if !chunked {
+		h.Set(bTransferEncoding, bChunked)
+	}
+	return newChunkedEncoder(m.Body), -1
+}
+
 func (m *Request) Method() string {
 	return string(m.Header.Method)
 }
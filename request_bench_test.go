@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"github.com/valyala/fasthttp"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 	"testing"
@@ -91,3 +93,57 @@ func Benchmark_fasthttp_ReadRequest_WriteTo(b *testing.B) {
 	}
 	b.ReportAllocs()
 }
+
+var benchServerRequest = []byte("GET /bench HTTP/1.1\r\nHost: localhost\r\nUser-Agent: bench\r\n\r\n")
+
+func benchmarkServer(b *testing.B, serve func(ln net.Listener)) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer ln.Close()
+	go serve(ln)
+
+	conn, e := net.Dial("tcp4", ln.Addr().String())
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Write(benchServerRequest)
+		resp, e := http.ReadResponse(br, nil)
+		if e != nil {
+			b.Fatal(e)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+var benchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello world"))
+})
+
+func Benchmark_Server(b *testing.B) {
+	s := &Server{Handler: benchHandler}
+	benchmarkServer(b, func(ln net.Listener) { s.Serve(ln) })
+	s.Close()
+}
+
+func Benchmark_http_Server(b *testing.B) {
+	s := &http.Server{Handler: benchHandler}
+	benchmarkServer(b, func(ln net.Listener) { s.Serve(ln) })
+	s.Close()
+}
+
+func Benchmark_Server_Native(b *testing.B) {
+	s := &Server{NativeHandler: HandlerFunc(func(w *ResponseWriter, req *Request) {
+		w.Write([]byte("hello world"))
+	})}
+	benchmarkServer(b, func(ln net.Listener) { s.Serve(ln) })
+	s.Close()
+}
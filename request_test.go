@@ -2,8 +2,12 @@ package http1
 
 import (
 	"bufio"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"testing"
 	"strings"
 	"bytes"
@@ -57,25 +61,154 @@ func Test_Request_Read(t *testing.T) {
 	assert.Equal(t, limitedRequest, w.Bytes())
 	ReleaseRequest(req)
 
-	readUntilCloseRequest := []byte(strings.Join([]string{
+	// 没有 Content-Length 和 chunked 时没有body，后面的数据属于下一个请求（RFC 7230 3.3.3）
+	noBodyRequest := []byte(strings.Join([]string{
 		"POST / HTTP/1.1",
 		"",
 		"123456",
 	}, "\r\n"))
 
-	go left.Write(readUntilCloseRequest)
+	go left.Write(noBodyRequest)
 	req = AcquireRequest()
 	time.AfterFunc(time.Millisecond*100, func() {
 		left.Close()
 	})
 	e = req.Read(br)
 	assert.Nil(t, e)
+	assert.Equal(t, http.NoBody, req.Body)
 	w = bytes.NewBuffer(nil)
 	req.WriteTo(w)
-	assert.Equal(t, readUntilCloseRequest, w.Bytes())
+	assert.Equal(t, "POST / HTTP/1.1\r\n\r\n", w.String())
+	rest, _ := ioutil.ReadAll(br)
+	assert.Equal(t, "123456", string(rest))
 
 }
 
+func Test_Request_WriteTo_Framing(t *testing.T) {
+	write := func(req *Request) (*http.Request, string, error) {
+		w := bytes.NewBuffer(nil)
+		if _, e := req.WriteTo(w); e != nil {
+			return nil, w.String(), e
+		}
+		r, e := http.ReadRequest(bufio.NewReader(bytes.NewReader(w.Bytes())))
+		if e != nil {
+			return nil, w.String(), e
+		}
+		b, e := ioutil.ReadAll(r.Body)
+		return r, string(b), e
+	}
+
+	// 长度已知的body自动设置 Content-Length
+	req := NewRequest("POST", "/", strings.NewReader("hello"))
+	r, body, e := write(req)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(5), r.ContentLength)
+	assert.Equal(t, "hello", body)
+
+	// 替换读取到的body
+	req, e = ReadRequest(bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc")))
+	assert.Nil(t, e)
+	req.Body = bytes.NewReader([]byte("longer"))
+	r, body, e = write(req)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(6), r.ContentLength)
+	assert.Equal(t, "longer", body)
+	ReleaseRequest(req)
+
+	// 长度未知时按 Content-Length 截断，不足时报错
+	req = NewRequest("POST", "/", io.MultiReader(strings.NewReader("hello world")))
+	req.Header.Add(bContentLength, []byte("5"))
+	r, body, e = write(req)
+	assert.Nil(t, e)
+	assert.Equal(t, "hello", body)
+
+	req = NewRequest("POST", "/", io.MultiReader(strings.NewReader("hello")))
+	req.Header.Add(bContentLength, []byte("20"))
+	_, _, e = write(req)
+	assert.Equal(t, ErrShortBody, e)
+
+	// 长度未知且没有 Content-Length 时使用 chunked
+	req = NewRequest("POST", "/", io.MultiReader(strings.NewReader("hello")))
+	r, body, e = write(req)
+	assert.Nil(t, e)
+	assert.Equal(t, []string{"chunked"}, r.TransferEncoding)
+	assert.Equal(t, "hello", body)
+
+	req = NewRequest("POST", "/", http.NoBody)
+	req.Header.Add(bContentLength, []byte("3"))
+	r, body, e = write(req)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), r.ContentLength)
+	assert.Equal(t, "0", r.Header.Get("Content-Length"))
+}
+
+// tcpPair 返回一对相连的TCP连接
+func tcpPair(t *testing.T) (a, b *net.TCPConn) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ln.Close()
+	dialed := make(chan net.Conn, 1)
+	go func() {
+		c, _ := net.Dial("tcp4", ln.Addr().String())
+		dialed <- c
+	}()
+	c, e := ln.Accept()
+	if e != nil {
+		t.Fatal(e)
+	}
+	a = (<-dialed).(*net.TCPConn)
+	return a, c.(*net.TCPConn)
+}
+
+func Test_Request_WriteTo_TCPConn(t *testing.T) {
+	client, src := tcpPair(t)
+	defer client.Close()
+	defer src.Close()
+	dst, upstream := tcpPair(t)
+	defer dst.Close()
+	defer upstream.Close()
+
+	body := bytes.Repeat([]byte("0123456789"), 100000)
+	go func() {
+		fmt.Fprintf(client, "POST /a HTTP/1.1\r\nHost: a\r\nContent-Length: %d\r\n\r\n", len(body))
+		client.Write(body)
+		client.Write([]byte("POST /b HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+	}()
+
+	// 转发两个请求：Content-Length 的body经过 splice，chunked 的body原样写出
+	go func() {
+		br := bufio.NewReader(src)
+		req := AcquireRequest()
+		defer ReleaseRequest(req)
+		req.SetBodyConn(src)
+		for i := 0; i < 2; i++ {
+			if e := req.Read(br); e != nil {
+				return
+			}
+			if _, e := req.WriteTo(dst); e != nil {
+				return
+			}
+		}
+	}()
+
+	ubr := bufio.NewReader(upstream)
+	r, e := http.ReadRequest(ubr)
+	assert.Nil(t, e)
+	assert.Equal(t, "/a", r.RequestURI)
+	b, e := ioutil.ReadAll(r.Body)
+	assert.Nil(t, e)
+	assert.True(t, bytes.Equal(body, b))
+
+	r, e = http.ReadRequest(ubr)
+	assert.Nil(t, e)
+	assert.Equal(t, "/b", r.RequestURI)
+	b, e = ioutil.ReadAll(r.Body)
+	assert.Nil(t, e)
+	assert.Equal(t, "abc", string(b))
+}
+
 func Test_GetHostPort(t *testing.T) {
 	req := NewRequest("GET", "http://baidu.com/", nil)
 	req.Header.Add([]byte("Host"), []byte("baidu.com"))
@@ -101,3 +234,70 @@ func Test_GetHostPort(t *testing.T) {
 	assert.Equal(t, 443, port)
 	assert.Nil(t, e)
 }
+
+func Test_Request_KeepAlive(t *testing.T) {
+	cases := []struct {
+		lines     []string
+		keepAlive bool
+	}{
+		{[]string{"GET / HTTP/1.1", "Host: a", "", ""}, true},
+		{[]string{"GET / HTTP/1.1", "Connection: close", "", ""}, false},
+		{[]string{"GET / HTTP/1.1", "Connection: foo, Close", "", ""}, false},
+		{[]string{"GET / HTTP/1.0", "Host: a", "", ""}, false},
+		{[]string{"GET / HTTP/1.0", "Connection: Keep-Alive", "", ""}, true},
+		{[]string{"POST / HTTP/1.1", "Content-Length: 1", "", "x"}, true},
+		{[]string{"POST / HTTP/1.1", "Transfer-Encoding: chunked", "", "0", "", ""}, true},
+		{[]string{"POST / HTTP/1.1", "Host: a", "", ""}, true},
+		{[]string{"DELETE / HTTP/1.0", "Connection: keep-alive", "", ""}, true},
+		{[]string{"CONNECT a:443 HTTP/1.1", "Host: a:443", "", ""}, false},
+	}
+
+	for _, c := range cases {
+		req, e := ReadRequest(bufio.NewReader(strings.NewReader(strings.Join(c.lines, "\r\n"))))
+		assert.Nil(t, e)
+		assert.Equal(t, c.keepAlive, req.KeepAlive(), c.lines[0]+" "+c.lines[1])
+		assert.Equal(t, !c.keepAlive, req.ShouldClose())
+		ReleaseRequest(req)
+	}
+}
+
+func Test_Request_DiscardBody(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(strings.Join([]string{
+		"POST /1 HTTP/1.1",
+		"Content-Length: 5",
+		"",
+		"12345GET /2 HTTP/1.1",
+		"",
+		"POST /3 HTTP/1.1",
+		"Transfer-Encoding: chunked",
+		"",
+		"5",
+		"hello",
+		"0",
+		"",
+		"POST /4 HTTP/1.1",
+		"Content-Length: 10",
+		"",
+		"0123456789",
+	}, "\r\n")))
+
+	req := AcquireRequest()
+	assert.Nil(t, req.Read(br))
+	assert.Nil(t, req.DiscardBody(5))
+
+	assert.Nil(t, req.Read(br))
+	assert.Equal(t, "/2", req.RequestURI())
+	assert.Nil(t, req.DiscardBody(0))
+
+	assert.Nil(t, req.Read(br))
+	assert.Equal(t, "/3", req.RequestURI())
+	assert.Nil(t, req.DiscardBody(1024))
+
+	assert.Nil(t, req.Read(br))
+	assert.Equal(t, "/4", req.RequestURI())
+	assert.Equal(t, ErrBodyTooLarge, req.DiscardBody(5))
+
+	req.Body = br
+	assert.Equal(t, ErrUnboundedBody, req.DiscardBody(5))
+	ReleaseRequest(req)
+}
@@ -0,0 +1,231 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type ResponseHeader struct {
+	Proto      []byte
+	StatusCode int
+	Reason     []byte // 为空时使用 http.StatusText(StatusCode)
+
+	headerFields
+}
+
+func NewResponseHeader() (h *ResponseHeader) {
+	h = new(ResponseHeader)
+	h.Proto = make([]byte, 0, 8)
+	h.Reason = make([]byte, 0, 8)
+	h.headers = make([][]byte, 0, 5)
+	return
+}
+
+func (h *ResponseHeader) reset() {
+	h.Proto = h.Proto[:0]
+	h.StatusCode = 0
+	h.Reason = h.Reason[:0]
+	h.headers = h.headers[:0]
+	h.cacheValid = false
+}
+
+// Read reads the status line and the header fields of a response.
+func (h *ResponseHeader) Read(r *bufio.Reader) (err error) {
+	var b []byte
+	if b, err = peekUntil(r, CRLF); err != nil {
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	mustDiscard(r, len(b))
+
+	if err = h.parseStatusLine(b[:len(b)-2]); err != nil {
+		return
+	}
+	return h.readFields(r)
+}
+
+// parseStatusLine 解析 "HTTP/1.1 200 OK"，Reason 可以为空
+func (h *ResponseHeader) parseStatusLine(b []byte) error {
+	n := bytes.IndexByte(b, ' ')
+	if n <= 0 || len(b) < n+4 || (len(b) > n+4 && b[n+4] != ' ') {
+		return &badStringError{"malformed HTTP status line", string(b)}
+	}
+	h.Proto = append(h.Proto[:0], b[:n]...)
+
+	code, _, err := parseUintBuf(b[n+1 : n+4])
+	if err != nil || code < 100 {
+		return &badStringError{"malformed HTTP status code", string(b[n+1 : n+4])}
+	}
+	h.StatusCode = code
+
+	if len(b) > n+5 {
+		h.Reason = append(h.Reason[:0], b[n+5:]...)
+	} else {
+		h.Reason = h.Reason[:0]
+	}
+	return nil
+}
+
+// ProtoAtLeast reports whether the HTTP version of the response is at least major.minor.
+func (h *ResponseHeader) ProtoAtLeast(major, minor int) bool {
+	maj, min, ok := http.ParseHTTPVersion(b2s(h.Proto))
+	return ok && (maj > major || maj == major && min >= minor)
+}
+
+func (h *ResponseHeader) appendStatusLine(b []byte) []byte {
+	if len(h.Proto) > 0 {
+		b = append(b, h.Proto...)
+	} else {
+		b = append(b, bHTTP11...)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(h.StatusCode), 10)
+	b = append(b, ' ')
+	if len(h.Reason) > 0 {
+		b = append(b, h.Reason...)
+	} else {
+		b = append(b, http.StatusText(h.StatusCode)...)
+	}
+	return append(b, CRLF...)
+}
+
+func (h *ResponseHeader) Bytes() []byte {
+	return h.AppendTo(nil)
+}
+
+// AppendTo appends the serialized header to dst and returns the result.
+// The serialization is cached until the header is changed.
+func (h *ResponseHeader) AppendTo(dst []byte) []byte {
+	return append(dst, h.serialized()...)
+}
+
+func (h *ResponseHeader) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(h.serialized())
+	return int64(n), err
+}
+
+func (h *ResponseHeader) serialized() []byte {
+	h.line = h.appendStatusLine(h.line[:0])
+	return h.headerFields.serialized(h.line)
+}
+
+var responsePool sync.Pool
+
+// Response is a response read from an upstream server. Like Request.Body,
+// Body yields the body as it is on the wire, chunked framing included, so
+// it can be relayed unchanged with WriteTo.
+type Response struct {
+	Header *ResponseHeader
+	Body   io.Reader
+
+	decoded *dechunkedReader // 见 DecodedBody
+}
+
+func AcquireResponse() (r *Response) {
+	if x := responsePool.Get(); x == nil {
+		r = &Response{Header: NewResponseHeader()}
+	} else {
+		r = x.(*Response)
+		r.Header.reset()
+		r.resetBody()
+	}
+	return
+}
+
+func ReleaseResponse(r *Response) {
+	responsePool.Put(r)
+}
+
+// Read reads a response to req from r. req decides whether a body follows
+// (HEAD, successful CONNECT); it may be nil.
+func (m *Response) Read(r *bufio.Reader, req *Request) (err error) {
+	m.Header.reset()
+	if err = m.Header.Read(r); err == nil {
+		m.readBody(r, req)
+	}
+	return
+}
+
+func (m *Response) resetBody() {
+	if m.Body != nil {
+		if cb, ok := m.Body.(*clientBody); ok {
+			cb.Close()
+		}
+		switch r := m.rawBody().(type) {
+		case *chunkedReader:
+			releaseChunkedReader(r)
+		case *io.LimitedReader:
+			releaseLimitedReader(r)
+		}
+
+		m.Body = nil
+	}
+	m.decoded = nil
+}
+
+// readBody 按 RFC 7230 3.3.3 决定响应body的长度
+func (m *Response) readBody(br *bufio.Reader, req *Request) {
+	m.resetBody()
+
+	status := m.Header.StatusCode
+	if !bodyAllowedForStatus(status) || req != nil && (bytes.Equal(req.Header.Method, bHEAD) ||
+		bytes.Equal(req.Header.Method, bCONNECT) && status/100 == 2) {
+		m.Body = http.NoBody
+
+	} else if m.Header.GetChunkedEncoding() {
+		m.Body = acquireChunkedReader(br)
+
+	} else if contentLength := m.Header.GetContentLength(); contentLength > 0 {
+		m.Body = acquireLimitedReader(br, int64(contentLength))
+
+	} else if contentLength == 0 {
+		m.Body = http.NoBody
+	} else {
+		m.Body = br // read until EOF
+	}
+}
+
+// KeepAlive reports whether the connection the response was read from can
+// carry another request.
+func (m *Response) KeepAlive() bool {
+	if _, untilEOF := m.rawBody().(*bufio.Reader); untilEOF {
+		return false
+	}
+	if m.Header.HasToken(bConnection, bClose) {
+		return false
+	}
+	if m.Header.ProtoAtLeast(1, 1) {
+		return true
+	}
+	return m.Header.HasToken(bConnection, bKeepAlive)
+}
+
+// rawBody 返回去掉 clientBody 之后的body，用于判断body的类型
+func (m *Response) rawBody() io.Reader {
+	if cb, ok := m.Body.(*clientBody); ok {
+		return cb.raw
+	}
+	return m.Body
+}
+
+func (m *Response) WriteTo(w io.Writer) (n int64, err error) {
+	n, err = m.Header.WriteTo(w)
+	if err == nil && m.Body != nil {
+		var written int64
+		written, err = io.Copy(w, m.Body)
+		n += written
+	}
+	return
+}
+
+func ReadResponse(r *bufio.Reader, req *Request) (resp *Response, err error) {
+	resp = AcquireResponse()
+	err = resp.Read(r, req)
+	return
+}
@@ -0,0 +1,82 @@
+package http1
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Response_Read(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(strings.Join([]string{
+		"HTTP/1.1 200 OK",
+		"Content-Length: 5",
+		"",
+		"hello" + "HTTP/1.1 404",
+		"transfer-encoding: chunked",
+		"",
+		"3",
+		"abc",
+		"0",
+		"",
+		"HTTP/1.0 204 No Content",
+		"",
+		"HTTP/1.0 200 OK",
+		"",
+		"rest",
+	}, "\r\n")))
+
+	resp, e := ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "HTTP/1.1", string(resp.Header.Proto))
+	assert.Equal(t, 200, resp.Header.StatusCode)
+	assert.Equal(t, "OK", string(resp.Header.Reason))
+	b, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "hello", string(b))
+	assert.True(t, resp.KeepAlive())
+
+	// 没有 Reason，body 按原样读出
+	assert.Nil(t, resp.Read(br, nil))
+	assert.Equal(t, 404, resp.Header.StatusCode)
+	assert.Equal(t, "", string(resp.Header.Reason))
+	assert.True(t, resp.Header.GetChunkedEncoding())
+	b, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "3\r\nabc\r\n0\r\n\r\n", string(b))
+
+	assert.Nil(t, resp.Read(br, nil))
+	assert.Equal(t, http.StatusNoContent, resp.Header.StatusCode)
+	assert.Equal(t, http.NoBody, resp.Body)
+	assert.False(t, resp.KeepAlive())
+
+	// 没有长度，读到连接关闭为止
+	assert.Nil(t, resp.Read(br, nil))
+	b, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "rest", string(b))
+	assert.False(t, resp.KeepAlive())
+	ReleaseResponse(resp)
+
+	// HEAD 的响应没有body
+	head := NewRequest("HEAD", "/", nil)
+	resp, e = ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n")), head)
+	assert.Nil(t, e)
+	assert.Equal(t, http.NoBody, resp.Body)
+	ReleaseResponse(resp)
+
+	_, e = ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 abc OK\r\n\r\n")), nil)
+	assert.NotNil(t, e)
+}
+
+func Test_ResponseHeader_AppendTo(t *testing.T) {
+	h := NewResponseHeader()
+	h.StatusCode = 200
+	h.Add([]byte("Content-Length"), []byte("0"))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", string(h.AppendTo(nil)))
+
+	h.StatusCode = 404
+	assert.Equal(t, "x HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", string(h.AppendTo([]byte("x "))))
+	h.Reason = append(h.Reason[:0], "Gone Fishing"...)
+	h.Set([]byte("Content-Length"), []byte("10"))
+	assert.Equal(t, "HTTP/1.1 404 Gone Fishing\r\nContent-Length: 10\r\n\r\n", string(h.Bytes()))
+}
@@ -0,0 +1,377 @@
+package http1
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var bLocation = []byte("Location")
+
+// Rule is a declarative rewrite rule. All the match fields that are set must
+// match; then the actions are applied in the order DelHeaders, SetHeaders,
+// AddHeaders, RewritePath, and finally Redirect or Reject, which end the
+// processing of the request.
+type Rule struct {
+	Methods []string `json:"methods,omitempty"`
+	// Host is compared case-insensitively without the port. "*.example.com"
+	// matches any subdomain of example.com.
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	PathRegexp string `json:"path_regexp,omitempty"`
+	// Header must be present, and equal to HeaderValue if that is set.
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"header_value,omitempty"`
+
+	// Response rules apply to the response instead: Header is matched and
+	// the header actions are applied on the response, the other match fields
+	// test the request. RewritePath, Redirect and Reject are not allowed.
+	Response bool `json:"response,omitempty"`
+
+	SetHeaders map[string]string `json:"set_headers,omitempty"`
+	AddHeaders map[string]string `json:"add_headers,omitempty"`
+	DelHeaders []string          `json:"del_headers,omitempty"`
+
+	// RewritePath replaces the path, keeping the query. With PathRegexp it
+	// is a template expanded with the submatches ($1, ${name}); otherwise,
+	// with PathPrefix only the prefix is replaced.
+	RewritePath string `json:"rewrite_path,omitempty"`
+
+	// Redirect is the Location of a redirect (expanded like RewritePath with
+	// PathRegexp), sent with RedirectStatus, 302 by default.
+	Redirect       string `json:"redirect,omitempty"`
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+
+	// Reject answers the request with this status.
+	Reject int `json:"reject,omitempty"`
+
+	// Last stops processing the rules after this one matched.
+	Last bool `json:"last,omitempty"`
+}
+
+// For CONNECT, Host is matched against the target of the tunnel, the path
+// is empty and RewritePath is not applied.
+
+type compiledRule struct {
+	methods     [][]byte
+	host        []byte // 小写，"*.example.com" 保存为 ".example.com"
+	hostSuffix  bool
+	pathPrefix  []byte
+	pathRegexp  *regexp.Regexp
+	header      []byte
+	headerValue []byte
+
+	set, add [][2][]byte
+	del      [][]byte
+
+	rewritePath    []byte
+	redirect       []byte
+	redirectStatus int
+	reject         int
+	last           bool
+}
+
+// Rewriter applies compiled rules to requests and responses. It is safe for
+// concurrent use.
+type Rewriter struct {
+	request, response []*compiledRule
+}
+
+// CompileRules validates and compiles rules.
+func CompileRules(rules []Rule) (*Rewriter, error) {
+	rw := &Rewriter{}
+	for i := range rules {
+		cr, err := compileRule(&rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("http1: rule %d: %v", i, err)
+		}
+		if rules[i].Response {
+			rw.response = append(rw.response, cr)
+		} else {
+			rw.request = append(rw.request, cr)
+		}
+	}
+	return rw, nil
+}
+
+func compileRule(r *Rule) (*compiledRule, error) {
+	cr := &compiledRule{
+		pathPrefix:     []byte(r.PathPrefix),
+		headerValue:    []byte(r.HeaderValue),
+		rewritePath:    []byte(r.RewritePath),
+		redirect:       []byte(r.Redirect),
+		redirectStatus: r.RedirectStatus,
+		reject:         r.Reject,
+		last:           r.Last,
+	}
+	for _, m := range r.Methods {
+		cr.methods = append(cr.methods, []byte(m))
+	}
+	if r.Host != "" {
+		host := strings.ToLower(r.Host)
+		if strings.HasPrefix(host, "*.") {
+			host, cr.hostSuffix = host[1:], true
+		}
+		cr.host = []byte(host)
+	}
+	if r.PathRegexp != "" {
+		re, err := regexp.Compile(r.PathRegexp)
+		if err != nil {
+			return nil, err
+		}
+		cr.pathRegexp = re
+	}
+	if r.Header != "" {
+		cr.header = headerKey(r.Header)
+	} else if r.HeaderValue != "" {
+		return nil, fmt.Errorf("header_value without header")
+	}
+
+	// map 的顺序不固定，按key排序
+	for _, kv := range []struct {
+		dst *[][2][]byte
+		m   map[string]string
+	}{{&cr.set, r.SetHeaders}, {&cr.add, r.AddHeaders}} {
+		keys := make([]string, 0, len(kv.m))
+		for k := range kv.m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			*kv.dst = append(*kv.dst, [2][]byte{headerKey(k), []byte(kv.m[k])})
+		}
+	}
+	for _, k := range r.DelHeaders {
+		cr.del = append(cr.del, headerKey(k))
+	}
+
+	switch {
+	case r.Response && (r.RewritePath != "" || r.Redirect != "" || r.Reject != 0):
+		return nil, fmt.Errorf("response rules cannot rewrite the path, redirect or reject")
+	case r.Redirect != "" && r.Reject != 0:
+		return nil, fmt.Errorf("both redirect and reject")
+	case r.Reject != 0 && (r.Reject < 400 || r.Reject > 599):
+		return nil, fmt.Errorf("reject status %d is not 4xx or 5xx", r.Reject)
+	case r.Redirect != "" && r.RedirectStatus == 0:
+		cr.redirectStatus = http.StatusFound
+	case r.Redirect != "" && (r.RedirectStatus < 300 || r.RedirectStatus > 399):
+		return nil, fmt.Errorf("redirect status %d is not 3xx", r.RedirectStatus)
+	}
+	return cr, nil
+}
+
+// headerKey 规范化key，cap 等于 len，Add/Set 追加 ": " 时不会写到共享的数组里
+func headerKey(s string) []byte {
+	b := []byte(s)
+	normalizeHeaderKey(b)
+	return b[:len(b):len(b)]
+}
+
+type rewriteBuffer struct {
+	path []byte
+}
+
+var rewriteBufferPool = sync.Pool{New: func() interface{} { return new(rewriteBuffer) }}
+
+// RewriteRequest applies the request rules to req. Paths are matched in the
+// normalized form of DefaultPathPolicy (the raw path if it is rejected by
+// the policy). A non-zero status means the request must be answered with
+// it instead of being forwarded; location is set for redirects.
+func (rw *Rewriter) RewriteRequest(req *Request) (status int, location string) {
+	if len(rw.request) == 0 {
+		return
+	}
+	b := rewriteBufferPool.Get().(*rewriteBuffer)
+	defer rewriteBufferPool.Put(b)
+	connect := bytes.Equal(req.Header.Method, bCONNECT)
+	b.path = b.path[:0]
+	if !connect {
+		b.path = matchPath(b.path, req.Header.RequestURI)
+	}
+	host := requestHost(req.Header)
+
+	for _, r := range rw.request {
+		if !r.matchRequest(req.Header, host, b.path) || !r.matchHeader(&req.Header.headerFields) {
+			continue
+		}
+		r.applyHeaders(&req.Header.headerFields)
+
+		if len(r.rewritePath) > 0 && !connect {
+			req.Header.RequestURI = r.rewriteURI(req.Header.RequestURI, b.path)
+			b.path = matchPath(b.path[:0], req.Header.RequestURI)
+			host = requestHost(req.Header)
+		}
+		if len(r.redirect) > 0 {
+			return r.redirectStatus, string(r.expand(nil, r.redirect, b.path))
+		}
+		if r.reject != 0 {
+			return r.reject, ""
+		}
+		if r.last {
+			break
+		}
+	}
+	return 0, ""
+}
+
+// RewriteResponse applies the response rules to resp, the response to req.
+func (rw *Rewriter) RewriteResponse(req *Request, resp *Response) {
+	if len(rw.response) == 0 {
+		return
+	}
+	b := rewriteBufferPool.Get().(*rewriteBuffer)
+	defer rewriteBufferPool.Put(b)
+	b.path = matchPath(b.path[:0], req.Header.RequestURI)
+	host := requestHost(req.Header)
+
+	for _, r := range rw.response {
+		if !r.matchRequest(req.Header, host, b.path) || !r.matchHeader(&resp.Header.headerFields) {
+			continue
+		}
+		r.applyHeaders(&resp.Header.headerFields)
+		if r.last {
+			break
+		}
+	}
+}
+
+func (r *compiledRule) matchRequest(h *RequestHeader, host, path []byte) bool {
+	if len(r.methods) > 0 {
+		found := false
+		for _, m := range r.methods {
+			if bytes.Equal(m, h.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.host) > 0 {
+		if r.hostSuffix {
+			if len(host) <= len(r.host) || !bytes.EqualFold(host[len(host)-len(r.host):], r.host) {
+				return false
+			}
+		} else if !bytes.EqualFold(host, r.host) {
+			return false
+		}
+	}
+	if len(r.pathPrefix) > 0 && !bytes.HasPrefix(path, r.pathPrefix) {
+		return false
+	}
+	if r.pathRegexp != nil && !r.pathRegexp.Match(path) {
+		return false
+	}
+	return true
+}
+
+func (r *compiledRule) matchHeader(h *headerFields) (ok bool) {
+	if len(r.header) == 0 {
+		return true
+	}
+	h.VisitFor(r.header, func(i int, value []byte) bool {
+		ok = len(r.headerValue) == 0 || bytes.Equal(value, r.headerValue)
+		return !ok
+	})
+	return
+}
+
+func (r *compiledRule) applyHeaders(h *headerFields) {
+	for _, k := range r.del {
+		h.Del(k)
+	}
+	for _, kv := range r.set {
+		h.Set(kv[0], kv[1])
+	}
+	for _, kv := range r.add {
+		h.Add(kv[0], kv[1])
+	}
+}
+
+// rewriteURI 替换 ruri 的 path，保留 scheme://authority 和 query
+func (r *compiledRule) rewriteURI(ruri, path []byte) []byte {
+	authority, _, query := splitRequestURI(ruri)
+	uri := append([]byte(nil), authority...)
+	if r.pathRegexp == nil && len(r.pathPrefix) > 0 {
+		uri = append(uri, r.rewritePath...)
+		uri = append(uri, path[len(r.pathPrefix):]...)
+	} else {
+		uri = r.expand(uri, r.rewritePath, path)
+	}
+	return append(uri, query...)
+}
+
+// expand 有 PathRegexp 时用 path 的子匹配展开 template
+func (r *compiledRule) expand(dst, template, path []byte) []byte {
+	if r.pathRegexp == nil {
+		return append(dst, template...)
+	}
+	return r.pathRegexp.Expand(dst, template, path, r.pathRegexp.FindSubmatchIndex(path))
+}
+
+// matchPath 是用于匹配的 path，不能规范化时使用原始的 path
+func matchPath(dst, ruri []byte) []byte {
+	path, err := AppendNormalizedPath(dst, ruri, nil)
+	if err == nil {
+		return path
+	}
+	raw, _ := requestPath(ruri)
+	return append(dst, raw...)
+}
+
+// splitRequestURI 把 request-target 分成 scheme://authority、path 和 ?query
+func splitRequestURI(ruri []byte) (authority, path, query []byte) {
+	start := 0
+	if len(ruri) > 0 && ruri[0] != '/' {
+		if i := indexColonSlashSlash(ruri); i != -1 {
+			start = i + len(colonSlashSlash)
+			for start < len(ruri) && ruri[start] != '/' && ruri[start] != '?' {
+				start++
+			}
+		}
+	}
+	end := start
+	for end < len(ruri) && ruri[end] != '?' {
+		end++
+	}
+	return ruri[:start], ruri[start:end], ruri[end:]
+}
+
+// requestHost 取出 CONNECT 的目标、absolute-form 或 Host header 里的主机名，不含端口
+func requestHost(h *RequestHeader) []byte {
+	var host []byte
+	ruri := h.RequestURI
+	if bytes.Equal(h.Method, bCONNECT) {
+		host = ruri
+	} else if i := indexColonSlashSlash(ruri); i != -1 {
+		host = ruri[i+len(colonSlashSlash):]
+		for j, c := range host {
+			if c == '/' || c == '?' {
+				host = host[:j]
+				break
+			}
+		}
+		if j := bytes.LastIndexByte(host, '@'); j != -1 {
+			host = host[j+1:]
+		}
+	} else {
+		host = h.Get(bHost)
+	}
+
+	if len(host) > 0 && host[0] == '[' {
+		// IPv6
+		if j := bytes.IndexByte(host, ']'); j != -1 {
+			return host[1:j]
+		}
+		return host
+	}
+	if j := bytes.LastIndexByte(host, ':'); j != -1 {
+		host = host[:j]
+	}
+	return host
+}
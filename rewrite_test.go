@@ -0,0 +1,201 @@
+package http1
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// raceEnabled 见 race_test.go
+var raceEnabled bool
+
+func readTestRequest(t testing.TB, s string) *Request {
+	req := AcquireRequest()
+	if e := req.Read(bufio.NewReader(strings.NewReader(s))); e != nil {
+		t.Fatal(e)
+	}
+	return req
+}
+
+func Test_CompileRules(t *testing.T) {
+	var rules []Rule
+	e := json.Unmarshal([]byte(`[
+		{"host": "*.example.com", "path_prefix": "/api/", "set_headers": {"x-api": "1"}},
+		{"methods": ["POST"], "reject": 405}
+	]`), &rules)
+	if !assert.Nil(t, e) {
+		return
+	}
+	rw, e := CompileRules(rules)
+	if assert.Nil(t, e) {
+		assert.Len(t, rw.request, 2)
+		assert.Equal(t, []byte("X-Api"), rw.request[0].set[0][0])
+	}
+
+	for _, r := range []Rule{
+		{PathRegexp: "("},
+		{Response: true, Reject: 403},
+		{Reject: 200},
+		{Redirect: "/x", RedirectStatus: 200},
+		{Redirect: "/x", Reject: 403},
+		{HeaderValue: "x"},
+	} {
+		_, e = CompileRules([]Rule{{}, r})
+		if assert.NotNil(t, e, "%+v", r) {
+			assert.True(t, strings.HasPrefix(e.Error(), "http1: rule 1: "))
+		}
+	}
+}
+
+func Test_Rewriter_RewriteRequest(t *testing.T) {
+	rw, e := CompileRules([]Rule{
+		{Host: "*.example.com", DelHeaders: []string{"cookie"}, SetHeaders: map[string]string{"X-Sub": "1"}},
+		{PathPrefix: "/old/", RewritePath: "/new/", AddHeaders: map[string]string{"x-rewritten": "prefix"}},
+		{PathRegexp: `^/user/(\d+)$`, RewritePath: "/users?id=$1"},
+		{Header: "X-Debug", HeaderValue: "on", Reject: 403},
+		{PathPrefix: "/moved", Redirect: "https://example.org/", RedirectStatus: 301},
+		{PathRegexp: `^/go/(\w+)`, Redirect: "/to/$1"},
+		{Methods: []string{"DELETE"}, Reject: 405, Last: true},
+		{Methods: []string{"DELETE"}, Reject: 500},
+	})
+	if !assert.Nil(t, e) {
+		return
+	}
+
+	for _, c := range []struct {
+		in       string
+		status   int
+		location string
+		out      string
+	}{
+		{"GET /old/a/../b?q=1 HTTP/1.1\r\nHost: a.example.com:8080\r\nCookie: x\r\n\r\n",
+			0, "", "GET /new/b?q=1 HTTP/1.1\r\nHost: a.example.com:8080\r\nX-Sub: 1\r\nX-Rewritten: prefix\r\n\r\n"},
+		{"GET http://EXAMPLE.com/old/x HTTP/1.1\r\nCookie: x\r\n\r\n",
+			0, "", "GET http://EXAMPLE.com/new/x HTTP/1.1\r\nCookie: x\r\nX-Rewritten: prefix\r\n\r\n"},
+		{"GET /user/42?a=b HTTP/1.1\r\n\r\n", 0, "", "GET /users?id=42?a=b HTTP/1.1\r\n\r\n"},
+		{"GET / HTTP/1.1\r\nX-Debug: off\r\nX-Debug: on\r\n\r\n", 403, "", ""},
+		{"GET /moved/x HTTP/1.1\r\n\r\n", 301, "https://example.org/", ""},
+		{"GET /go/home/x HTTP/1.1\r\n\r\n", 302, "/to/home", ""},
+		{"DELETE / HTTP/1.1\r\n\r\n", 405, "", ""},
+	} {
+		req := readTestRequest(t, c.in)
+		status, location := rw.RewriteRequest(req)
+		assert.Equal(t, c.status, status, c.in)
+		assert.Equal(t, c.location, location, c.in)
+		if c.out != "" {
+			assert.Equal(t, c.out, string(req.Header.Bytes()), c.in)
+		}
+		ReleaseRequest(req)
+	}
+}
+
+func Test_Rewriter_NoAllocs(t *testing.T) {
+	rw, _ := CompileRules([]Rule{
+		{Methods: []string{"POST"}, Reject: 405},
+		{Host: "*.example.com", SetHeaders: map[string]string{"X-A": "1"}},
+		{PathRegexp: `^/admin(/|$)`, Reject: 403},
+		{PathPrefix: "/static/", Header: "X-Debug", HeaderValue: "on", Reject: 403},
+		{Response: true, Header: "Server", DelHeaders: []string{"Server"}},
+	})
+	req := readTestRequest(t, "GET /static/./a/b?x=1 HTTP/1.1\r\nHost: example.org\r\nX-Debug: off\r\n\r\n")
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	status, _ := rw.RewriteRequest(req)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "GET /static/./a/b?x=1 HTTP/1.1\r\nHost: example.org\r\nX-Debug: off\r\n\r\n", string(req.Header.Bytes()))
+	if raceEnabled {
+		return
+	}
+	n := testing.AllocsPerRun(100, func() {
+		rw.RewriteRequest(req)
+		rw.RewriteResponse(req, resp)
+	})
+	assert.Equal(t, 0.0, n)
+}
+
+func Test_Proxy_Rewriter(t *testing.T) {
+	origin, _ := startOrigin()
+	defer origin.Close()
+	host := origin.Listener.Addr().String()
+
+	rw, _ := CompileRules([]Rule{
+		{PathPrefix: "/v1/", RewritePath: "/v2/", SetHeaders: map[string]string{"X-Forwarded-By": "rules"}},
+		{PathPrefix: "/old", Redirect: "/new"},
+		{PathPrefix: "/private", Reject: 403},
+		{Response: true, PathPrefix: "/v2/", SetHeaders: map[string]string{"X-Hop": "rewritten"}},
+	})
+	p := &Proxy{Rewriter: rw}
+	defer p.Close()
+	conn, e := net.Dial("tcp4", startProxy(t, p))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "GET http://%s/v1/a?x=1 HTTP/1.1\r\nHost: %s\r\n\r\n", host, host)
+	resp, e := http.ReadResponse(br, nil)
+	if !assert.Nil(t, e) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "GET "+host+" /v2/a?x=1 ", string(body))
+	assert.Equal(t, "rules", resp.Header.Get("X-Forwarded-By"))
+	assert.Equal(t, "rewritten", resp.Header.Get("X-Hop"))
+
+	// 直接回复的请求丢弃body，连接继续使用
+	fmt.Fprintf(conn, "POST http://%s/old HTTP/1.1\r\nHost: %s\r\nContent-Length: 4\r\n\r\nping", host, host)
+	resp, e = http.ReadResponse(br, nil)
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.Equal(t, 302, resp.StatusCode)
+		assert.Equal(t, "/new", resp.Header.Get("Location"))
+		assert.Equal(t, "", resp.Header.Get("Connection"))
+	}
+
+	fmt.Fprintf(conn, "GET http://%s/private HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host, host)
+	resp, e = http.ReadResponse(br, nil)
+	if assert.Nil(t, e) {
+		resp.Body.Close()
+		assert.Equal(t, 403, resp.StatusCode)
+		assert.True(t, resp.Close)
+	}
+}
+
+func Test_Proxy_RewriterConnect(t *testing.T) {
+	rw, _ := CompileRules([]Rule{
+		{Host: "*.blocked.com", Reject: 403},
+		{Methods: []string{"CONNECT"}, PathPrefix: "/", RewritePath: "/x"},
+	})
+	p := &Proxy{Rewriter: rw}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	// 规则对 CONNECT 同样有效，匹配隧道的目标
+	conn, e := net.Dial("tcp4", addr)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT www.blocked.com:443 HTTP/1.1\r\nHost: www.blocked.com:443\r\n\r\n")
+	resp, e := http.ReadResponse(bufio.NewReader(conn), nil)
+	if assert.Nil(t, e) {
+		assert.Equal(t, 403, resp.StatusCode)
+		assert.True(t, resp.Close)
+	}
+
+	req := readTestRequest(t, "CONNECT a.com:443 HTTP/1.1\r\n\r\n")
+	status, _ := rw.RewriteRequest(req)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "a.com:443", req.RequestURI())
+	ReleaseRequest(req)
+}
@@ -0,0 +1,275 @@
+package http1
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib-go/http1/internal/conntrack"
+)
+
+const (
+	// 未超过该大小的响应直接使用 Content-Length，否则使用 chunked
+	responseBufferSize = 4096
+	// 处理完请求后，最多丢弃这么多未读的body以复用连接
+	maxDiscardBodySize = 256 << 10
+)
+
+var (
+	bClose     = []byte("close")
+	bKeepAlive = []byte("keep-alive")
+)
+
+// Server serves net/http handlers using the request parser of this package.
+// If NativeHandler is set it is used instead of Handler, skipping the
+// conversion to net/http types.
+type Server struct {
+	Handler       http.Handler
+	NativeHandler Handler
+
+	ReadTimeout     time.Duration // 读取单个请求头的超时
+	ReadBodyTimeout time.Duration // 从读完请求头到handler返回期间读取body的超时，为0时使用 ReadTimeout
+	WriteTimeout    time.Duration // 写单个响应的超时
+	IdleTimeout     time.Duration // keep-alive 时等待下一个请求的超时，为0时使用 ReadTimeout
+
+	// CheckContinue is called for requests with "Expect: 100-continue" before
+	// the handler. Returning http.StatusContinue sends "100 Continue" right
+	// away; any other status is sent as the final response and the handler
+	// is skipped. If nil, "100 Continue" is sent on the first Body.Read.
+	CheckContinue func(req *Request) int
+
+	tracker conntrack.Tracker
+}
+
+func ListenAndServe(addr string, handler http.Handler) error {
+	s := &Server{Handler: handler}
+	return s.ListenAndServe(addr)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each of them in a new goroutine.
+// It always returns a non-nil error; after Close it returns http.ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.tracker.Serve(ln, s.serveConn)
+}
+
+// Close closes all listeners and connections of s.
+func (s *Server) Close() error {
+	return s.tracker.CloseAll()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+		s.tracker.TrackConn(conn, false)
+	}()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetBodyConn(conn)
+
+	for first := true; ; first = false {
+		timeout := s.ReadTimeout
+		if !first && s.IdleTimeout > 0 {
+			timeout = s.IdleTimeout
+		}
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		if err := req.Read(br); err != nil {
+			if err != io.EOF {
+				writeErrorResponse(bw, http.StatusBadRequest)
+			}
+			return
+		}
+		// body 由handler读取，handler 返回后丢弃剩下的body也在这个期限内
+		if timeout = s.ReadBodyTimeout; timeout == 0 {
+			timeout = s.ReadTimeout
+		}
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		if s.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		}
+
+		w := acquireResponseWriter(conn, br, bw, req)
+		w.closeAfter = req.ShouldClose()
+
+		if !s.handleExpect(w, req) {
+			// 已经设置了最终响应，不再调用handler
+		} else if s.NativeHandler != nil {
+			s.NativeHandler.ServeHTTP1(w, req)
+		} else {
+			s.serveStd(w, req, conn)
+		}
+
+		if w.hijacked {
+			hijacked = true
+			releaseResponseWriter(w)
+			return
+		}
+		err := w.finish()
+		closeAfter := w.closeAfter
+		releaseResponseWriter(w)
+		if err != nil || closeAfter {
+			return
+		}
+
+		// 丢弃handler没有读完的body，否则无法读取下一个请求
+		if req.DiscardBody(maxDiscardBodySize) != nil {
+			return
+		}
+	}
+}
+
+// handleExpect 处理 Expect 头，返回false表示请求已被拒绝，不应再调用handler
+func (s *Server) handleExpect(w *ResponseWriter, req *Request) bool {
+	if !req.HasExpect() || !req.Header.ProtoAtLeast(1, 1) {
+		return true
+	}
+	if !req.ExpectContinue() {
+		w.WriteHeader(http.StatusExpectationFailed)
+		w.closeAfter = true
+		return false
+	}
+
+	if s.CheckContinue == nil {
+		req.EnableAutoContinue(w.bw)
+		return true
+	}
+	if status := s.CheckContinue(req); status != http.StatusContinue {
+		w.WriteHeader(status)
+		w.closeAfter = true
+		return false
+	}
+	if err := WriteContinue(w.bw); err != nil {
+		w.err = err
+		return false
+	}
+	return true
+}
+
+func (s *Server) serveStd(w *ResponseWriter, req *Request, conn net.Conn) {
+	r, err := req.ToStdRequest()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.closeAfter = true
+		return
+	}
+	r.RemoteAddr = conn.RemoteAddr().String()
+
+	w.sniff = true
+	rw := &response{w: w, header: make(http.Header)}
+	s.Handler.ServeHTTP(rw, r)
+	rw.finish()
+}
+
+func writeErrorResponse(bw *bufio.Writer, status int) {
+	writeShortResponse(bw, status, false, nil)
+}
+
+// writeShortResponse 写一个没有body的响应，values 是 key 的值，keepAlive 为false时加上 Connection: close
+func writeShortResponse(bw *bufio.Writer, status int, keepAlive bool, key []byte, values ...string) error {
+	bw.WriteString("HTTP/1.1 ")
+	bw.WriteString(strconv.Itoa(status))
+	bw.WriteByte(' ')
+	bw.WriteString(http.StatusText(status))
+	bw.Write(CRLF)
+	for _, v := range values {
+		bw.Write(key)
+		bw.WriteString(": ")
+		bw.WriteString(v)
+		bw.Write(CRLF)
+	}
+	if !keepAlive {
+		bw.WriteString("Connection: close\r\n")
+	}
+	bw.WriteString("Content-Length: 0\r\n\r\n")
+	return bw.Flush()
+}
+
+// skipRequest 在不转发请求、直接回复时丢弃最多 limit 字节的请求body，返回连接是否还能继续使用。
+// 客户端在等待 100 Continue 时不读body（它不会发送），回复之后关闭连接
+func skipRequest(req *Request, limit int64) (keepAlive bool) {
+	if req.ExpectContinue() && !req.ContinueSent() {
+		return false
+	}
+	return req.KeepAlive() && req.DiscardBody(limit) == nil
+}
+
+// response 把 http.ResponseWriter 适配到原生的 ResponseWriter 上
+type response struct {
+	w           *ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+// WriteHeader 和 net/http 一样，在这里固定下响应头，之后对 Header() 的修改不再生效
+func (r *response) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.w.WriteHeader(status)
+
+	keys := make([]string, 0, len(r.header))
+	for k := range r.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := r.w.Header
+	for _, k := range keys {
+		for _, v := range r.header[k] {
+			h.Add([]byte(k), []byte(v))
+		}
+	}
+}
+
+func (r *response) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.w.Write(p)
+}
+
+func (r *response) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.w.Flush()
+}
+
+func (r *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.w.Hijack()
+}
+
+func (r *response) finish() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,140 @@
+package http1
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t testing.TB, handler http.Handler) (s *Server, addr string) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s = &Server{Handler: handler}
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func Test_Server(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		fmt.Fprintf(w, "hello %s", r.Host)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", responseBufferSize+1)))
+	})
+	mux.HandleFunc("/skip", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s, addr := startServer(t, mux)
+	defer s.Close()
+
+	var conns int
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conns++
+			return net.Dial(network, addr)
+		},
+	}}
+
+	for i := 0; i < 3; i++ {
+		resp, e := client.Get("http://" + addr + "/hello")
+		assert.Nil(t, e)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "hello "+addr, string(body))
+		assert.Equal(t, "/hello", resp.Header.Get("X-Path"))
+		assert.Equal(t, int64(len(body)), resp.ContentLength)
+	}
+
+	resp, e := client.Post("http://"+addr+"/echo", "text/plain", strings.NewReader("ping"))
+	assert.Nil(t, e)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ping", string(body))
+
+	// handler 没读body，连接仍然可以复用
+	resp, e = client.Post("http://"+addr+"/skip", "text/plain", strings.NewReader("ignored"))
+	assert.Nil(t, e)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, e = client.Get("http://" + addr + "/big")
+	assert.Nil(t, e)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, responseBufferSize+1, len(body))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	resp, e = client.Get("http://" + addr + "/notfound")
+	assert.Nil(t, e)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	assert.Equal(t, 1, conns)
+}
+
+func Test_Server_ConnectionClose(t *testing.T) {
+	s, addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bye"))
+	}))
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.0\r\nHost: a\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "bye", string(body))
+
+	// HTTP/1.0 没有 keep-alive，服务端应该关闭连接
+	_, e = br.ReadByte()
+	assert.Equal(t, io.EOF, e)
+}
+
+func Test_Server_ReadBodyTimeout(t *testing.T) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	errs := make(chan error, 1)
+	s := &Server{ReadTimeout: time.Second, ReadBodyTimeout: 50 * time.Millisecond, NativeHandler: HandlerFunc(func(w *ResponseWriter, req *Request) {
+		_, e := ioutil.ReadAll(req.Body)
+		errs <- e
+	})}
+	go s.Serve(ln)
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", ln.Addr().String())
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	// 客户端只发送了一部分body，读取超时
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n01"))
+	select {
+	case e = <-errs:
+		if ne, ok := e.(net.Error); assert.True(t, ok, "%v", e) {
+			assert.True(t, ne.Timeout())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("body read did not time out")
+	}
+}
@@ -0,0 +1,264 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib-go/http1/internal/conntrack"
+)
+
+// Protocol is what Sniff found at the start of a connection.
+type Protocol int
+
+const (
+	ProtoUnknown Protocol = iota
+	ProtoHTTP1            // HTTP/1.x request line
+	ProtoHTTP2            // HTTP/2 connection preface (prior knowledge / h2c)
+	ProtoTLS              // TLS ClientHello
+	ProtoSOCKS4           // SOCKS4 / SOCKS4a request
+	ProtoSOCKS5           // SOCKS5 greeting
+	ProtoPROXY            // HAProxy PROXY protocol header, v1 or v2
+)
+
+var protocolNames = [...]string{"unknown", "HTTP/1", "HTTP/2", "TLS", "SOCKS4", "SOCKS5", "PROXY"}
+
+func (p Protocol) String() string {
+	if p < 0 || int(p) >= len(protocolNames) {
+		return protocolNames[0]
+	}
+	return protocolNames[p]
+}
+
+var (
+	errMuxClosed = errors.New("http1: mux listener closed")
+
+	http2Preface  = []byte("PRI * HTTP/2.0\r\n")
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// 请求行中方法名的最大长度，超过时不认为是HTTP请求
+const maxMethodLen = 16
+
+// Sniff peeks at the first bytes buffered by br and reports the protocol.
+// Nothing is consumed. It reads only as many bytes as needed to decide, so
+// a client waiting for the server (e.g. after a short SOCKS greeting) does
+// not block it. An error means the connection ended before a decision.
+func Sniff(br *bufio.Reader) (Protocol, error) {
+	b, err := br.Peek(1)
+	if err != nil {
+		return ProtoUnknown, err
+	}
+
+	switch c := b[0]; {
+	case c == 0x16:
+		// TLS record: handshake, version 3.x
+		if b, err = br.Peek(3); err != nil {
+			return ProtoUnknown, err
+		}
+		if b[1] == 3 {
+			return ProtoTLS, nil
+		}
+	case c == 5:
+		// VER NMETHODS METHODS...
+		if b, err = br.Peek(2); err != nil {
+			return ProtoUnknown, err
+		}
+		if b[1] > 0 {
+			return ProtoSOCKS5, nil
+		}
+	case c == 4:
+		// VER CMD(1 CONNECT / 2 BIND) DSTPORT DSTIP
+		if b, err = br.Peek(2); err != nil {
+			return ProtoUnknown, err
+		}
+		if b[1] == 1 || b[1] == 2 {
+			return ProtoSOCKS4, nil
+		}
+	case c == '\r':
+		if b, err = br.Peek(len(proxyV2Sig)); err != nil {
+			return ProtoUnknown, err
+		}
+		if bytes.Equal(b, proxyV2Sig) {
+			return ProtoPROXY, nil
+		}
+	case 'A' <= c && c <= 'Z':
+		return sniffRequestLine(br)
+	}
+	return ProtoUnknown, nil
+}
+
+// sniffRequestLine 区分 HTTP/1 请求行、HTTP/2 preface 和 PROXY v1，它们都以大写的单词加空格开头
+func sniffRequestLine(br *bufio.Reader) (Protocol, error) {
+	for i := 2; i <= maxMethodLen+1; i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return ProtoUnknown, err
+		}
+		c := b[i-1]
+		if c == ' ' {
+			break
+		}
+		if !('A' <= c && c <= 'Z' || c == '-' || c == '_') {
+			return ProtoUnknown, nil
+		}
+		if i == maxMethodLen+1 {
+			return ProtoUnknown, nil
+		}
+	}
+
+	b, _ := br.Peek(br.Buffered())
+	switch {
+	case bytes.HasPrefix(b, proxyV1Prefix):
+		return ProtoPROXY, nil
+	case bytes.HasPrefix(b, http2Preface[:4]):
+		if b, err := br.Peek(len(http2Preface)); err != nil {
+			return ProtoUnknown, err
+		} else if bytes.Equal(b, http2Preface) {
+			return ProtoHTTP2, nil
+		}
+	}
+	return ProtoHTTP1, nil
+}
+
+// SniffConn runs Sniff on conn and returns a conn that replays the bytes
+// read while sniffing, to be handed to the server of that protocol.
+func SniffConn(conn net.Conn) (Protocol, net.Conn, error) {
+	br := bufio.NewReader(conn)
+	p, err := Sniff(br)
+	return p, RestoreConn(conn, br, nil), err
+}
+
+// Mux serves several protocols on one listener: each accepted connection is
+// sniffed and passed to the handler registered for its protocol, or closed
+// if there is none.
+type Mux struct {
+	// SniffTimeout limits the wait for the first bytes; 0 means no limit.
+	SniffTimeout time.Duration
+
+	mu        sync.Mutex
+	handlers  map[Protocol]func(conn net.Conn)
+	listeners []*muxListener
+
+	tracker conntrack.Tracker
+}
+
+// Handle registers handler for connections of protocol p. handler runs in
+// the goroutine of the connection and owns it.
+func (m *Mux) Handle(p Protocol, handler func(conn net.Conn)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[Protocol]func(conn net.Conn))
+	}
+	m.handlers[p] = handler
+}
+
+// Listen returns a listener that accepts the connections of protocol p,
+// e.g. to pass to Server.Serve. It is closed by Mux.Close.
+func (m *Mux) Listen(p Protocol) net.Listener {
+	l := &muxListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	m.mu.Lock()
+	m.listeners = append(m.listeners, l)
+	m.mu.Unlock()
+
+	m.Handle(p, func(conn net.Conn) {
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.Close()
+		}
+	})
+	return l
+}
+
+// Serve accepts connections on ln and serves each of them in a new goroutine.
+// It always returns a non-nil error; after Close it returns http.ErrServerClosed.
+func (m *Mux) Serve(ln net.Listener) error {
+	return m.tracker.Serve(ln, func(conn net.Conn) {
+		p, c, err := m.sniff(conn)
+		// 交给handler之后连接不再由 Mux 管理
+		m.tracker.TrackConn(conn, false)
+		if err != nil || m.tracker.Closed() {
+			conn.Close()
+			return
+		}
+		m.dispatch(p, c)
+	})
+}
+
+// ServeConn sniffs a single connection and passes it to its handler.
+func (m *Mux) ServeConn(conn net.Conn) {
+	p, c, err := m.sniff(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	m.dispatch(p, c)
+}
+
+func (m *Mux) sniff(conn net.Conn) (Protocol, net.Conn, error) {
+	if m.SniffTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(m.SniffTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return SniffConn(conn)
+}
+
+func (m *Mux) dispatch(p Protocol, conn net.Conn) {
+	m.mu.Lock()
+	handler := m.handlers[p]
+	m.mu.Unlock()
+
+	if handler == nil {
+		conn.Close()
+		return
+	}
+	handler(conn)
+}
+
+// Close closes the listeners given to Serve, the connections being sniffed
+// and the listeners returned by Listen.
+func (m *Mux) Close() error {
+	err := m.tracker.CloseAll()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.listeners {
+		l.Close()
+	}
+	return err
+}
+
+type muxListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errMuxClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return muxAddr{}
+}
+
+type muxAddr struct{}
+
+func (muxAddr) Network() string { return "mux" }
+func (muxAddr) String() string  { return "mux" }
@@ -0,0 +1,90 @@
+package http1
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Sniff(t *testing.T) {
+	cases := []struct {
+		in    string
+		proto Protocol
+	}{
+		{"GET / HTTP/1.1\r\n", ProtoHTTP1},
+		{"POST /upload HTTP/1.1\r\n", ProtoHTTP1},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", ProtoHTTP2},
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 1111 80\r\n", ProtoPROXY},
+		{"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c", ProtoPROXY},
+		{"\x16\x03\x01\x02\x00", ProtoTLS},
+		{"\x05\x01\x00", ProtoSOCKS5},
+		{"\x04\x01\x00\x50\x7f\x00\x00\x01\x00", ProtoSOCKS4},
+		{"SSH-2.0-OpenSSH\r\n", ProtoUnknown},
+		{"\x00\x01\x02", ProtoUnknown},
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(strings.NewReader(c.in))
+		p, e := Sniff(br)
+		assert.Nil(t, e, c.in)
+		assert.Equal(t, c.proto, p, c.in)
+
+		rest, _ := ioutil.ReadAll(br)
+		assert.Equal(t, c.in, string(rest), "Sniff 不消耗数据")
+	}
+
+	_, e := Sniff(bufio.NewReader(strings.NewReader("GE")))
+	assert.Equal(t, io.EOF, e)
+}
+
+func Test_Mux(t *testing.T) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	m := &Mux{SniffTimeout: time.Second}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})}
+	go s.Serve(m.Listen(ProtoHTTP1))
+	m.Handle(ProtoSOCKS5, func(conn net.Conn) {
+		defer conn.Close()
+		b := make([]byte, 3)
+		io.ReadFull(conn, b)
+		conn.Write([]byte{5, 0})
+	})
+	go m.Serve(ln)
+	defer s.Close()
+	defer m.Close()
+
+	resp, e := http.Get("http://" + ln.Addr().String() + "/")
+	if assert.Nil(t, e) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "hello", string(body))
+	}
+
+	conn, e := net.Dial("tcp4", ln.Addr().String())
+	if assert.Nil(t, e) {
+		conn.Write([]byte{5, 1, 0})
+		b, e := ioutil.ReadAll(conn)
+		assert.Nil(t, e)
+		assert.Equal(t, []byte{5, 0}, b)
+		conn.Close()
+	}
+
+	// 没有注册的协议直接关闭
+	conn, e = net.Dial("tcp4", ln.Addr().String())
+	if assert.Nil(t, e) {
+		conn.Write([]byte("\x16\x03\x01\x00\x00"))
+		b, _ := ioutil.ReadAll(conn)
+		assert.Empty(t, b)
+		conn.Close()
+	}
+}
@@ -0,0 +1,229 @@
+// Package socks implements a SOCKS5 (RFC 1928, RFC 1929) and SOCKS4a server
+// built on package http1: plain HTTP connections on the same port can be
+// handed to an http1.Proxy, and HTTP/1 streams inside CONNECT tunnels can be
+// parsed for logging or filtering.
+package socks
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lib-go/http1"
+	"github.com/lib-go/http1/internal/conntrack"
+)
+
+var (
+	ErrBadVersion    = errors.New("socks: unsupported protocol version")
+	ErrNoAuthMethod  = errors.New("socks: no acceptable authentication method")
+	ErrAuthFailed    = errors.New("socks: authentication failed")
+	ErrBadCommand    = errors.New("socks: unsupported command")
+	ErrBadAddrType   = errors.New("socks: unsupported address type")
+	ErrNotAllowed    = errors.New("socks: request not allowed")
+	ErrSOCKS4NoLogin = errors.New("socks: SOCKS4 refused, authentication is required")
+)
+
+// Commands of a request.
+const (
+	CmdConnect      = 1
+	CmdBind         = 2
+	CmdUDPAssociate = 3
+)
+
+// Request is a SOCKS request read after the handshake.
+type Request struct {
+	Version int // 4 or 5
+	Command byte
+	Addr    string // 目标 host:port，host 可能是域名
+	User    string // SOCKS5 的用户名或 SOCKS4 的 USERID
+
+	// 客户端地址
+	RemoteAddr net.Addr
+}
+
+// Server is a SOCKS server. CONNECT and, for SOCKS5, UDP ASSOCIATE are
+// supported; BIND is refused.
+type Server struct {
+	// Authenticate, if set, requires SOCKS5 username/password authentication
+	// and refuses SOCKS4, which cannot carry a password.
+	Authenticate func(user, password string) bool
+
+	// Allow, if set, is called before a request is carried out, and for
+	// each destination of the datagrams of a UDP association. Returning
+	// false refuses it.
+	Allow func(req *Request) bool
+
+	Dialer      http1.Dialer // nil means net.Dialer with DialTimeout
+	DialTimeout time.Duration
+
+	HandshakeTimeout time.Duration // 握手和读取请求的超时
+	IdleTimeout      time.Duration // 隧道和 UDP 关联的空闲超时
+
+	// HTTP, if set, serves connections that start with an HTTP/1 request
+	// instead of a SOCKS handshake, e.g. http1.Proxy.ServeConn, so that one
+	// port serves both kinds of proxy clients. It owns the connection.
+	HTTP func(conn net.Conn)
+
+	// InspectHTTP, if set, is called for each request of a CONNECT tunnel
+	// whose client stream is HTTP/1, before the request is forwarded; hreq
+	// may be modified. Returning an error closes the tunnel. Other streams
+	// are relayed unchanged.
+	InspectHTTP func(req *Request, hreq *http1.Request) error
+
+	tracker conntrack.Tracker
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each of them in a new goroutine.
+// It always returns a non-nil error; after Close it returns http.ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.tracker.Serve(ln, func(conn net.Conn) {
+		defer s.tracker.TrackConn(conn, false)
+		s.ServeConn(conn)
+	})
+}
+
+// Close closes all listeners and the connections being served.
+func (s *Server) Close() error {
+	return s.tracker.CloseAll()
+}
+
+// ServeConn serves a single client connection and closes it when done.
+func (s *Server) ServeConn(conn net.Conn) {
+	if s.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+	br := bufio.NewReader(conn)
+	proto, err := http1.Sniff(br)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch proto {
+	case http1.ProtoSOCKS5:
+		s.serveSOCKS5(conn, br)
+	case http1.ProtoSOCKS4:
+		s.serveSOCKS4(conn, br)
+	case http1.ProtoHTTP1:
+		if s.HTTP != nil {
+			conn.SetDeadline(time.Time{})
+			s.HTTP(http1.RestoreConn(conn, br, nil))
+			return
+		}
+	}
+	conn.Close()
+}
+
+func (s *Server) dial(addr string) (net.Conn, error) {
+	dialer := s.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: s.DialTimeout}
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// connect 在回复成功之后转发数据，br 里已经缓冲的客户端数据先发给目标
+func (s *Server) connect(req *Request, conn net.Conn, br *bufio.Reader, target net.Conn) error {
+	conn.SetDeadline(time.Time{})
+	if s.InspectHTTP != nil {
+		return s.inspect(req, conn, br, target)
+	}
+	if n := br.Buffered(); n > 0 {
+		if _, err := io.CopyN(target, br, int64(n)); err != nil {
+			return err
+		}
+	}
+	_, err := http1.Relay(conn, target, s.IdleTimeout)
+	return err
+}
+
+// inspect 转发隧道里的数据，客户端发送的是 HTTP/1 时逐个解析请求交给 InspectHTTP。
+// 响应方向不需要解析，原样转发即可
+func (s *Server) inspect(req *Request, conn net.Conn, br *bufio.Reader, target net.Conn) error {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, &idleReader{target, s.IdleTimeout})
+		closeWrite(conn)
+		close(done)
+	}()
+
+	client := &idleReader{conn, s.IdleTimeout}
+	err := s.inspectRequests(req, bufio.NewReader(io.MultiReader(io.LimitReader(br, int64(br.Buffered())), client)), target)
+	if err == nil {
+		err = closeWrite(target)
+	}
+	if err != nil {
+		// 结束响应方向
+		target.Close()
+	}
+	<-done
+	return err
+}
+
+// inspectRequests 转发客户端方向的数据直到EOF
+func (s *Server) inspectRequests(req *Request, br *bufio.Reader, target net.Conn) error {
+	// 服务端先说话的协议（比如 SMTP）响应方向已经在转发，这里只需等客户端的第一段数据
+	proto, err := http1.Sniff(br)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	if proto != http1.ProtoHTTP1 {
+		_, err = io.Copy(target, br)
+		return err
+	}
+
+	hreq := http1.AcquireRequest()
+	defer http1.ReleaseRequest(hreq)
+	for {
+		if err = hreq.Read(br); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if err = s.InspectHTTP(req, hreq); err != nil {
+			return err
+		}
+		if _, err = hreq.WriteTo(target); err != nil {
+			return err
+		}
+		if hreq.IsUpgrade() || hreq.Method() == "CONNECT" {
+			// 之后不再是 HTTP/1
+			_, err = io.Copy(target, br)
+			return err
+		}
+	}
+}
+
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// idleReader 每次读之前设置读超时
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(b []byte) (int, error) {
+	if r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return r.conn.Read(b)
+}
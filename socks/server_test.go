@@ -0,0 +1,300 @@
+package socks
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib-go/http1"
+)
+
+func startSocks(t testing.TB, s *Server) string {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func startEcho(t testing.TB) net.Listener {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+// connect5 完成 SOCKS5 握手并发送请求，返回回复码和 BND 地址
+func connect5(t *testing.T, conn net.Conn, cmd byte, target string, auth ...string) (byte, string) {
+	br := bufio.NewReader(conn)
+	if len(auth) == 2 {
+		conn.Write([]byte{5, 1, methodUserPass})
+	} else {
+		conn.Write([]byte{5, 1, methodNoAuth})
+	}
+	b := make([]byte, 2)
+	if _, e := io.ReadFull(br, b); e != nil || b[1] == methodNoAcceptable {
+		return methodNoAcceptable, ""
+	}
+	if len(auth) == 2 {
+		msg := append([]byte{1, byte(len(auth[0]))}, auth[0]...)
+		msg = append(append(msg, byte(len(auth[1]))), auth[1]...)
+		conn.Write(msg)
+		io.ReadFull(br, b)
+		if b[1] != 0 {
+			return RepNotAllowed, ""
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(target)
+	p, _ := strconv.Atoi(port)
+	req := []byte{5, cmd, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		req = appendAddr(req, &net.TCPAddr{IP: ip, Port: p})
+	} else {
+		req = append(append(req, atypDomain, byte(len(host))), host...)
+		req = append(req, byte(p>>8), byte(p))
+	}
+	conn.Write(req)
+
+	b = make([]byte, 3)
+	if _, e := io.ReadFull(br, b); e != nil {
+		t.Fatal(e)
+	}
+	addr, e := readAddr(br)
+	assert.Nil(t, e)
+	return b[1], addr
+}
+
+func Test_SOCKS5_Connect(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	s := &Server{}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	rep, _ := connect5(t, conn, CmdConnect, echo.Addr().String())
+	assert.Equal(t, byte(RepSucceeded), rep)
+
+	conn.Write([]byte("hello"))
+	b := make([]byte, 5)
+	io.ReadFull(conn, b)
+	assert.Equal(t, "hello", string(b))
+
+	// 目标拒绝连接
+	ln, _ := net.Listen("tcp4", "127.0.0.1:0")
+	closed := ln.Addr().String()
+	ln.Close()
+	conn2, _ := net.Dial("tcp4", addr)
+	defer conn2.Close()
+	rep, _ = connect5(t, conn2, CmdConnect, closed)
+	assert.Equal(t, byte(RepConnectionRefused), rep)
+
+	// BIND 不支持
+	conn3, _ := net.Dial("tcp4", addr)
+	defer conn3.Close()
+	rep, _ = connect5(t, conn3, CmdBind, echo.Addr().String())
+	assert.Equal(t, byte(RepCommandNotSupported), rep)
+}
+
+func Test_SOCKS5_UserPass(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	var users []string
+	s := &Server{
+		Authenticate: func(user, password string) bool { return user == "u" && password == "p" },
+		Allow: func(req *Request) bool {
+			users = append(users, req.User)
+			return true
+		},
+	}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	conn, _ := net.Dial("tcp4", addr)
+	defer conn.Close()
+	rep, _ := connect5(t, conn, CmdConnect, echo.Addr().String(), "u", "p")
+	assert.Equal(t, byte(RepSucceeded), rep)
+	assert.Equal(t, []string{"u"}, users)
+
+	conn2, _ := net.Dial("tcp4", addr)
+	defer conn2.Close()
+	rep, _ = connect5(t, conn2, CmdConnect, echo.Addr().String(), "u", "x")
+	assert.Equal(t, byte(RepNotAllowed), rep)
+
+	conn3, _ := net.Dial("tcp4", addr)
+	defer conn3.Close()
+	rep, _ = connect5(t, conn3, CmdConnect, echo.Addr().String())
+	assert.Equal(t, byte(methodNoAcceptable), rep, "要求认证时不接受 no-auth")
+}
+
+func Test_SOCKS4a(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	s := &Server{}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	p, _ := strconv.Atoi(port)
+	for _, domain := range []string{"", "localhost"} {
+		conn, _ := net.Dial("tcp4", addr)
+		req := []byte{4, CmdConnect, byte(p >> 8), byte(p), 127, 0, 0, 1}
+		if domain != "" {
+			req = []byte{4, CmdConnect, byte(p >> 8), byte(p), 0, 0, 0, 1}
+		}
+		req = append(req, "user\x00"...)
+		if domain != "" {
+			req = append(append(req, domain...), 0)
+		}
+		conn.Write(append(req, "ping"...))
+
+		b := make([]byte, 12)
+		_, e := io.ReadFull(conn, b)
+		assert.Nil(t, e)
+		assert.Equal(t, byte(rep4Granted), b[1])
+		assert.Equal(t, "ping", string(b[8:]), "回复之前发送的数据也被转发")
+		conn.Close()
+	}
+}
+
+func Test_SOCKS5_UDPAssociate(t *testing.T) {
+	pc, e := net.ListenPacket("udp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer pc.Close()
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, from, e := pc.ReadFrom(b)
+			if e != nil {
+				return
+			}
+			pc.WriteTo(append([]byte("echo "), b[:n]...), from)
+		}
+	}()
+
+	s := &Server{}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	conn, _ := net.Dial("tcp4", addr)
+	defer conn.Close()
+	rep, relay := connect5(t, conn, CmdUDPAssociate, "0.0.0.0:0")
+	assert.Equal(t, byte(RepSucceeded), rep)
+
+	uc, e := net.Dial("udp4", relay)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer uc.Close()
+	target := pc.LocalAddr().(*net.UDPAddr)
+	uc.Write(append(appendAddr([]byte{0, 0, 0}, target), "hi"...))
+
+	b := make([]byte, 1024)
+	n, e := uc.Read(b)
+	if assert.Nil(t, e) {
+		from, e := readAddr(strings.NewReader(string(b[3:n])))
+		assert.Nil(t, e)
+		assert.Equal(t, target.String(), from)
+		assert.Equal(t, "echo hi", string(b[n-7:n]))
+	}
+}
+
+func Test_Server_InspectHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path+" "+r.Header.Get("X-Inspected"))
+	}))
+	defer origin.Close()
+
+	var mu sync.Mutex
+	var seen []string
+	s := &Server{
+		InspectHTTP: func(req *Request, hreq *http1.Request) error {
+			mu.Lock()
+			seen = append(seen, req.Addr+" "+hreq.RequestURI())
+			mu.Unlock()
+			hreq.Header.Set([]byte("X-Inspected"), []byte("yes"))
+			return nil
+		},
+	}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	u, _ := url.Parse("socks5://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	for _, path := range []string{"/a", "/b"} {
+		resp, e := client.Get(origin.URL + path)
+		if assert.Nil(t, e) {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, path+" yes", string(body))
+		}
+	}
+
+	host := strings.TrimPrefix(origin.URL, "http://")
+	mu.Lock()
+	assert.Equal(t, []string{host + " /a", host + " /b"}, seen, "两个请求在同一个隧道里")
+	mu.Unlock()
+
+	// 不是 HTTP 的数据原样转发
+	echo := startEcho(t)
+	defer echo.Close()
+	conn, _ := net.Dial("tcp4", addr)
+	defer conn.Close()
+	connect5(t, conn, CmdConnect, echo.Addr().String())
+	conn.Write([]byte{0, 1, 2})
+	conn.(*net.TCPConn).CloseWrite()
+	b, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, []byte{0, 1, 2}, b)
+}
+
+func Test_Server_HTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+
+	p := &http1.Proxy{}
+	defer p.Close()
+	s := &Server{HTTP: p.ServeConn}
+	addr := startSocks(t, s)
+	defer s.Close()
+
+	u, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	resp, e := client.Get(origin.URL)
+	if assert.Nil(t, e) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "hello", string(body))
+	}
+}
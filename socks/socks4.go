@@ -0,0 +1,100 @@
+package socks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	// 用户ID和域名的最大长度
+	maxSOCKS4String = 255
+
+	rep4Granted  = 90
+	rep4Rejected = 91
+)
+
+// serveSOCKS4 处理 SOCKS4 和 SOCKS4a 请求：VN CD DSTPORT DSTIP USERID NULL，
+// SOCKS4a 的 DSTIP 是 0.0.0.x，之后跟着以NULL结尾的域名
+func (s *Server) serveSOCKS4(conn net.Conn, br *bufio.Reader) error {
+	var b [8]byte
+	if _, err := io.ReadFull(br, b[:]); err != nil {
+		return err
+	}
+	user, err := readString4(br)
+	if err != nil {
+		return err
+	}
+
+	port := int(binary.BigEndian.Uint16(b[2:4]))
+	host := net.IP(b[4:8]).String()
+	if b[4] == 0 && b[5] == 0 && b[6] == 0 && b[7] != 0 {
+		if host, err = readString4(br); err != nil {
+			return err
+		}
+	}
+	req := &Request{
+		Version:    4,
+		Command:    b[1],
+		Addr:       net.JoinHostPort(host, strconv.Itoa(port)),
+		User:       user,
+		RemoteAddr: conn.RemoteAddr(),
+	}
+
+	if s.Authenticate != nil {
+		writeReply4(conn, rep4Rejected, nil)
+		return ErrSOCKS4NoLogin
+	}
+	if req.Command != CmdConnect {
+		writeReply4(conn, rep4Rejected, nil)
+		return ErrBadCommand
+	}
+	if s.Allow != nil && !s.Allow(req) {
+		writeReply4(conn, rep4Rejected, nil)
+		return ErrNotAllowed
+	}
+
+	target, err := s.dial(req.Addr)
+	if err != nil {
+		writeReply4(conn, rep4Rejected, nil)
+		return err
+	}
+	defer target.Close()
+
+	if err = writeReply4(conn, rep4Granted, target.LocalAddr()); err != nil {
+		return err
+	}
+	return s.connect(req, conn, br, target)
+}
+
+func readString4(br *bufio.Reader) (string, error) {
+	var s []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == 0 {
+			return string(s), nil
+		}
+		if len(s) == maxSOCKS4String {
+			return "", ErrBadAddrType
+		}
+		s = append(s, c)
+	}
+}
+
+// writeReply4 发送 VN(0) CD DSTPORT DSTIP
+func writeReply4(conn net.Conn, rep byte, addr net.Addr) error {
+	b := []byte{0, rep, 0, 0, 0, 0, 0, 0}
+	if a, ok := addr.(*net.TCPAddr); ok {
+		binary.BigEndian.PutUint16(b[2:4], uint16(a.Port))
+		if ip4 := a.IP.To4(); ip4 != nil {
+			copy(b[4:], ip4)
+		}
+	}
+	_, err := conn.Write(b)
+	return err
+}
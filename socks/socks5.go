@@ -0,0 +1,319 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	version5 = 5
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	userPassVersion = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// Reply codes of SOCKS5 (RFC 1928 6).
+const (
+	RepSucceeded           = 0x00
+	RepGeneralFailure      = 0x01
+	RepNotAllowed          = 0x02
+	RepNetworkUnreachable  = 0x03
+	RepHostUnreachable     = 0x04
+	RepConnectionRefused   = 0x05
+	RepTTLExpired          = 0x06
+	RepCommandNotSupported = 0x07
+	RepAddrNotSupported    = 0x08
+)
+
+// serveSOCKS5 处理握手、认证和请求
+func (s *Server) serveSOCKS5(conn net.Conn, br *bufio.Reader) error {
+	user, err := s.handshake5(conn, br)
+	if err != nil {
+		return err
+	}
+
+	// VER CMD RSV ATYP DST.ADDR DST.PORT
+	var b [3]byte
+	if _, err = io.ReadFull(br, b[:]); err != nil {
+		return err
+	}
+	if b[0] != version5 {
+		return ErrBadVersion
+	}
+	addr, err := readAddr(br)
+	if err != nil {
+		if err == ErrBadAddrType {
+			writeReply5(conn, RepAddrNotSupported, nil)
+		}
+		return err
+	}
+
+	req := &Request{Version: 5, Command: b[1], Addr: addr, User: user, RemoteAddr: conn.RemoteAddr()}
+	switch req.Command {
+	case CmdConnect, CmdUDPAssociate:
+	default:
+		writeReply5(conn, RepCommandNotSupported, nil)
+		return ErrBadCommand
+	}
+	if s.Allow != nil && !s.Allow(req) {
+		writeReply5(conn, RepNotAllowed, nil)
+		return ErrNotAllowed
+	}
+
+	if req.Command == CmdUDPAssociate {
+		return s.udpAssociate(req, conn, br)
+	}
+
+	target, err := s.dial(req.Addr)
+	if err != nil {
+		writeReply5(conn, dialErrorReply(err), nil)
+		return err
+	}
+	defer target.Close()
+
+	if err = writeReply5(conn, RepSucceeded, target.LocalAddr()); err != nil {
+		return err
+	}
+	return s.connect(req, conn, br, target)
+}
+
+// handshake5 选择认证方法并完成认证，返回用户名
+func (s *Server) handshake5(conn net.Conn, br *bufio.Reader) (user string, err error) {
+	// VER NMETHODS METHODS
+	var b [2]byte
+	if _, err = io.ReadFull(br, b[:]); err != nil {
+		return
+	}
+	methods := make([]byte, b[1])
+	if _, err = io.ReadFull(br, methods); err != nil {
+		return
+	}
+
+	method := byte(methodNoAuth)
+	if s.Authenticate != nil {
+		method = methodUserPass
+	}
+	if bytes.IndexByte(methods, method) == -1 {
+		conn.Write([]byte{version5, methodNoAcceptable})
+		return "", ErrNoAuthMethod
+	}
+	if _, err = conn.Write([]byte{version5, method}); err != nil || method == methodNoAuth {
+		return
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	if _, err = io.ReadFull(br, b[:]); err != nil {
+		return
+	}
+	if b[0] != userPassVersion {
+		return "", ErrBadVersion
+	}
+	uname := make([]byte, b[1])
+	if _, err = io.ReadFull(br, uname); err != nil {
+		return
+	}
+	plen, err := br.ReadByte()
+	if err != nil {
+		return
+	}
+	passwd := make([]byte, plen)
+	if _, err = io.ReadFull(br, passwd); err != nil {
+		return
+	}
+
+	if !s.Authenticate(string(uname), string(passwd)) {
+		conn.Write([]byte{userPassVersion, 1})
+		return "", ErrAuthFailed
+	}
+	_, err = conn.Write([]byte{userPassVersion, 0})
+	return string(uname), err
+}
+
+// writeReply5 发送 VER REP RSV ATYP BND.ADDR BND.PORT，addr 为nil时使用 0.0.0.0:0
+func writeReply5(conn net.Conn, rep byte, addr net.Addr) error {
+	b := []byte{version5, rep, 0}
+	_, err := conn.Write(appendAddr(b, addr))
+	return err
+}
+
+// readAddr 读取 ATYP DST.ADDR DST.PORT，返回 host:port
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host []byte
+	switch atyp[0] {
+	case atypIPv4:
+		host = make([]byte, net.IPv4len)
+	case atypIPv6:
+		host = make([]byte, net.IPv6len)
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		host = make([]byte, n[0])
+	default:
+		return "", ErrBadAddrType
+	}
+	if _, err := io.ReadFull(r, host); err != nil {
+		return "", err
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+
+	h := string(host)
+	if atyp[0] != atypDomain {
+		h = net.IP(host).String()
+	}
+	return net.JoinHostPort(h, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendAddr 编码 ATYP ADDR PORT，支持 *net.TCPAddr、*net.UDPAddr
+func appendAddr(b []byte, addr net.Addr) []byte {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		b = append(append(b, atypIPv4), ip4...)
+	} else {
+		b = append(append(b, atypIPv6), ip.To16()...)
+	}
+	return append(b, byte(port>>8), byte(port))
+}
+
+// dialErrorReply 把连接目标的错误转换成回复码
+func dialErrorReply(err error) byte {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return RepTTLExpired
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if _, ok := err.(*net.DNSError); ok {
+		return RepHostUnreachable
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	switch err {
+	case syscall.ECONNREFUSED:
+		return RepConnectionRefused
+	case syscall.ENETUNREACH:
+		return RepNetworkUnreachable
+	case syscall.EHOSTUNREACH:
+		return RepHostUnreachable
+	}
+	return RepGeneralFailure
+}
+
+// udpAssociate 处理 UDP ASSOCIATE：TCP 连接保持打开期间，在一个UDP端口上转发
+// 客户端的数据报。req.Addr 是客户端将要使用的地址，可以是全0
+func (s *Server) udpAssociate(req *Request, conn net.Conn, br *bufio.Reader) error {
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		writeReply5(conn, RepGeneralFailure, nil)
+		return err
+	}
+	defer pc.Close()
+
+	if err = writeReply5(conn, RepSucceeded, pc.LocalAddr()); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	// TCP 连接关闭时关联结束
+	go func() {
+		io.Copy(ioutil.Discard, br)
+		pc.Close()
+	}()
+
+	var clientIP net.IP
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = a.IP
+	}
+	var client *net.UDPAddr
+	if h, p, err := net.SplitHostPort(req.Addr); err == nil {
+		if ip := net.ParseIP(h); ip != nil && !ip.IsUnspecified() && p != "0" {
+			port, _ := strconv.Atoi(p)
+			client = &net.UDPAddr{IP: ip, Port: port}
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		if s.IdleTimeout > 0 {
+			pc.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		src, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		if client == nil && src.IP.Equal(clientIP) {
+			// 第一个来自客户端IP的数据报确定客户端的端口
+			client = src
+		}
+		if client != nil && src.IP.Equal(client.IP) && src.Port == client.Port {
+			s.sendDatagram(req, pc, buf[:n])
+		} else if client != nil {
+			// 目标发回的数据报，加上 SOCKS 的头转发给客户端
+			pkt := appendAddr([]byte{0, 0, 0}, src)
+			pc.WriteTo(append(pkt, buf[:n]...), client)
+		}
+	}
+}
+
+// sendDatagram 解析客户端数据报的头 RSV FRAG ATYP DST.ADDR DST.PORT 并发给目标，
+// 不支持分片，分片的数据报被丢弃
+func (s *Server) sendDatagram(req *Request, pc net.PacketConn, b []byte) {
+	if len(b) < 4 || b[2] != 0 {
+		return
+	}
+	r := bytes.NewReader(b[3:])
+	addr, err := readAddr(r)
+	if err != nil {
+		return
+	}
+	if s.Allow != nil && !s.Allow(&Request{Version: 5, Command: CmdUDPAssociate, Addr: addr, User: req.User, RemoteAddr: req.RemoteAddr}) {
+		return
+	}
+	dst, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+	pc.WriteTo(b[len(b)-r.Len():], dst)
+}
@@ -0,0 +1,156 @@
+package http1
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+var bHTTP11 = []byte("HTTP/1.1")
+
+// ToStdRequest converts m to a *http.Request. The returned request shares
+// m's body stream, so m must not be released before the body is consumed.
+func (m *Request) ToStdRequest() (r *http.Request, err error) {
+	r = &http.Request{
+		Method:     m.Method(),
+		RequestURI: m.RequestURI(),
+		Proto:      string(m.Header.Proto),
+		Header:     make(http.Header, len(m.Header.headers)),
+		Close:      m.ShouldClose(),
+	}
+
+	var ok bool
+	if r.ProtoMajor, r.ProtoMinor, ok = http.ParseHTTPVersion(r.Proto); !ok {
+		return nil, &badStringError{"malformed HTTP version", r.Proto}
+	}
+
+	if r.Method == http.MethodConnect && len(m.Header.RequestURI) > 0 && m.Header.RequestURI[0] != '/' {
+		// CONNECT host:port 是authority-form
+		r.URL = &url.URL{Host: r.RequestURI}
+	} else if r.URL, err = url.ParseRequestURI(r.RequestURI); err != nil {
+		return nil, err
+	}
+
+	for _, header := range m.Header.headers {
+		if len(header) == 0 {
+			continue
+		}
+		key, value := splitHeaderKeyValue(header)
+		if len(key) == 0 {
+			continue
+		}
+		k := http.CanonicalHeaderKey(string(key))
+		r.Header[k] = append(r.Header[k], string(value))
+	}
+
+	// 和 net/http 一样，Host 头不放在 Header 里
+	r.Host = r.URL.Host
+	if r.Host == "" {
+		r.Host = r.Header.Get("Host")
+	}
+	delete(r.Header, "Host")
+
+	switch body := m.rawBody().(type) {
+	case nil:
+		r.Body = http.NoBody
+	case *chunkedReader:
+		r.TransferEncoding = []string{"chunked"}
+		r.ContentLength = -1
+		r.Header.Del("Transfer-Encoding")
+		r.Header.Del("Content-Length")
+		r.Body = ioutil.NopCloser(httputil.NewChunkedReader(m.Body))
+	case *io.LimitedReader:
+		r.ContentLength = body.N
+		r.Body = ioutil.NopCloser(m.Body)
+	default:
+		if body == http.NoBody || r.Method == http.MethodConnect {
+			// CONNECT 之后的数据属于隧道，不是body
+			r.Body = http.NoBody
+		} else {
+			r.ContentLength = -1
+			r.Body = ioutil.NopCloser(m.Body)
+		}
+	}
+
+	return r, nil
+}
+
+// FromStdRequest fills a pooled Request from r. Header order follows the
+// sorted keys of r.Header with Host first, since http.Header is a map.
+// The body is sent chunked when r.ContentLength is unknown.
+func FromStdRequest(r *http.Request) (m *Request, err error) {
+	if r.URL == nil && r.RequestURI == "" {
+		return nil, errors.New("http1: missing request URL")
+	}
+
+	m = AcquireRequest()
+	h := m.Header
+
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	h.Method = append(h.Method[:0], method...)
+
+	switch {
+	case r.RequestURI != "":
+		h.RequestURI = append(h.RequestURI[:0], r.RequestURI...)
+	case method == http.MethodConnect && r.URL.Path == "":
+		h.RequestURI = append(h.RequestURI[:0], r.URL.Host...)
+	default:
+		h.RequestURI = append(h.RequestURI[:0], r.URL.RequestURI()...)
+	}
+
+	if r.Proto == "" {
+		h.Proto = append(h.Proto[:0], bHTTP11...)
+	} else {
+		h.Proto = append(h.Proto[:0], r.Proto...)
+	}
+
+	host := r.Host
+	if host == "" && r.URL != nil {
+		host = r.URL.Host
+	}
+	if host != "" {
+		h.Add(bHost, []byte(host))
+	}
+
+	keys := make([]string, 0, len(r.Header))
+	for k := range r.Header {
+		if k != "Host" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range r.Header[k] {
+			h.Add([]byte(k), []byte(v))
+		}
+	}
+
+	chunked := len(r.TransferEncoding) > 0 && r.TransferEncoding[len(r.TransferEncoding)-1] == "chunked"
+	hasBody := r.Body != nil && r.Body != http.NoBody
+
+	switch {
+	case !hasBody:
+		m.Body = http.NoBody
+	case r.ContentLength > 0 && !chunked:
+		h.Set(bContentLength, strconv.AppendInt(nil, r.ContentLength, 10))
+		m.Body = io.LimitReader(r.Body, r.ContentLength)
+	default:
+		h.Del(bContentLength)
+		h.Set(bTransferEncoding, bChunked)
+		m.Body = newChunkedEncoder(r.Body)
+	}
+
+	if r.Close && len(h.Get(bConnection)) == 0 {
+		h.Add(bConnection, []byte("close"))
+	}
+
+	return m, nil
+}
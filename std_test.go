@@ -0,0 +1,144 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var stdRequests = [][]string{
+	{
+		"GET /index.html?a=1&b=2 HTTP/1.1",
+		"Host: baidu.com",
+		"User-Agent: curl/7.54.0",
+		"Accept: */*",
+		"Accept: text/html",
+		"",
+		"",
+	},
+	{
+		"GET http://baidu.com/search?q=x HTTP/1.1",
+		"Host: baidu.com",
+		"",
+		"",
+	},
+	{
+		"POST /upload HTTP/1.1",
+		"Host: baidu.com",
+		"Content-Type: text/plain",
+		"Content-Length: 12",
+		"",
+		"hello, world",
+	},
+	{
+		"POST /upload HTTP/1.1",
+		"Host: baidu.com",
+		"Transfer-Encoding: chunked",
+		"",
+		"4",
+		"Wiki",
+		"5",
+		"pedia",
+		"0",
+		"",
+		"",
+	},
+	{
+		"CONNECT google.com:443 HTTP/1.1",
+		"Host: google.com:443",
+		"",
+		"",
+	},
+	{
+		"GET / HTTP/1.0",
+		"Host: baidu.com",
+		"Connection: keep-alive",
+		"",
+		"",
+	},
+}
+
+func Test_Request_ToStdRequest(t *testing.T) {
+	for _, lines := range stdRequests {
+		raw := []byte(strings.Join(lines, "\r\n"))
+
+		req, e := ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+		assert.Nil(t, e)
+		got, e := req.ToStdRequest()
+		assert.Nil(t, e)
+
+		want, e := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+		assert.Nil(t, e)
+
+		assert.Equal(t, want.Method, got.Method, lines[0])
+		assert.Equal(t, want.RequestURI, got.RequestURI, lines[0])
+		assert.Equal(t, want.URL, got.URL, lines[0])
+		assert.Equal(t, want.Proto, got.Proto, lines[0])
+		assert.Equal(t, want.ProtoMajor, got.ProtoMajor, lines[0])
+		assert.Equal(t, want.ProtoMinor, got.ProtoMinor, lines[0])
+		assert.Equal(t, want.Header, got.Header, lines[0])
+		assert.Equal(t, want.Host, got.Host, lines[0])
+		assert.Equal(t, want.ContentLength, got.ContentLength, lines[0])
+		assert.Equal(t, want.TransferEncoding, got.TransferEncoding, lines[0])
+
+		wantBody, _ := ioutil.ReadAll(want.Body)
+		gotBody, e := ioutil.ReadAll(got.Body)
+		assert.Nil(t, e)
+		assert.Equal(t, wantBody, gotBody, lines[0])
+
+		ReleaseRequest(req)
+	}
+}
+
+func Test_FromStdRequest(t *testing.T) {
+	for _, lines := range stdRequests {
+		raw := []byte(strings.Join(lines, "\r\n"))
+
+		std, e := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+		assert.Nil(t, e)
+		req, e := FromStdRequest(std)
+		assert.Nil(t, e)
+
+		// 再交给 net/http 解析一遍，应该和原始报文等价
+		w := bytes.NewBuffer(nil)
+		_, e = req.WriteTo(w)
+		assert.Nil(t, e)
+		again, e := http.ReadRequest(bufio.NewReader(w))
+		assert.Nil(t, e)
+
+		want, _ := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+		assert.Equal(t, want.Method, again.Method, lines[0])
+		assert.Equal(t, want.RequestURI, again.RequestURI, lines[0])
+		assert.Equal(t, want.Proto, again.Proto, lines[0])
+		assert.Equal(t, want.Header, again.Header, lines[0])
+		assert.Equal(t, want.Host, again.Host, lines[0])
+		assert.Equal(t, want.TransferEncoding, again.TransferEncoding, lines[0])
+
+		wantBody, _ := ioutil.ReadAll(want.Body)
+		gotBody, _ := ioutil.ReadAll(again.Body)
+		assert.Equal(t, wantBody, gotBody, lines[0])
+
+		ReleaseRequest(req)
+	}
+
+	// 客户端构造的请求，长度未知时使用chunked
+	std, _ := http.NewRequest("PUT", "http://baidu.com/a b", ioutil.NopCloser(strings.NewReader("payload")))
+	std.Header.Set("X-Token", "1")
+	req, e := FromStdRequest(std)
+	assert.Nil(t, e)
+	assert.Equal(t, "/a%20b", req.RequestURI())
+	assert.Equal(t, []byte("baidu.com"), req.Header.Get(bHost))
+	assert.Equal(t, []byte("1"), req.Header.Get([]byte("X-Token")))
+	assert.True(t, req.Header.GetChunkedEncoding())
+
+	w := bytes.NewBuffer(nil)
+	req.WriteTo(w)
+	again, e := http.ReadRequest(bufio.NewReader(w))
+	assert.Nil(t, e)
+	body, _ := ioutil.ReadAll(again.Body)
+	assert.Equal(t, "payload", string(body))
+}
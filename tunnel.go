@@ -0,0 +1,188 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNotConnect = errors.New("http1: not a CONNECT request")
+
+	connectEstablished = []byte("HTTP/1.1 200 Connection Established\r\n\r\n")
+	bCONNECT           = []byte("CONNECT")
+)
+
+// Dialer is satisfied by *net.Dialer and by proxy dialers such as the ones
+// from golang.org/x/net/proxy.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+type TunnelOptions struct {
+	// Dialer connects to the CONNECT target; nil means net.Dialer with DialTimeout.
+	Dialer      Dialer
+	DialTimeout time.Duration
+	// IdleTimeout closes the tunnel when neither direction has carried data
+	// for that long. 0 means no timeout, which lets the kernel splice the
+	// two TCP connections directly.
+	IdleTimeout time.Duration
+}
+
+// TunnelStats counts the bytes relayed by Tunnel.
+type TunnelStats struct {
+	Sent     int64 // client -> target
+	Received int64 // target -> client
+}
+
+// Tunnel handles a CONNECT request: it dials the target, answers
+// "200 Connection Established" on conn and relays data both ways until
+// both sides are done. br is the reader req was read from; bytes it has
+// already buffered are forwarded first. A failed dial is answered with
+// 502 and returned as error. conn is not closed by Tunnel.
+func Tunnel(req *Request, conn net.Conn, br *bufio.Reader, opts *TunnelOptions) (stats TunnelStats, err error) {
+	if !bytes.Equal(req.Header.Method, bCONNECT) {
+		return stats, ErrNotConnect
+	}
+	if opts == nil {
+		opts = &TunnelOptions{}
+	}
+
+	host, port, err := req.GetHostPort()
+	if err != nil {
+		writeErrorResponse(bufio.NewWriter(conn), 400)
+		return
+	}
+
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: opts.DialTimeout}
+	}
+	target, err := dialer.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		writeErrorResponse(bufio.NewWriter(conn), 502)
+		return
+	}
+	defer target.Close()
+
+	if _, err = conn.Write(connectEstablished); err != nil {
+		return
+	}
+
+	// 客户端可能在收到200之前就发送了数据（比如TLS ClientHello）
+	if br != nil && br.Buffered() > 0 {
+		var n int64
+		n, err = io.CopyN(target, br, int64(br.Buffered()))
+		stats.Sent += n
+		if err != nil {
+			return
+		}
+	}
+
+	err = splice(conn, target, opts.IdleTimeout, &stats)
+	return
+}
+
+// Relay copies data both ways between client and target until both sides
+// are done, as Tunnel does after the handshake. Neither conn is closed.
+func Relay(client, target net.Conn, idleTimeout time.Duration) (stats TunnelStats, err error) {
+	err = splice(client, target, idleTimeout, &stats)
+	return
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// splice 双向转发数据直到两个方向都结束，一个方向读到EOF时对另一端做半关闭
+func splice(client, target net.Conn, idleTimeout time.Duration, stats *TunnelStats) error {
+	t := &tunnelState{idleTimeout: idleTimeout}
+	t.touch()
+
+	var wg sync.WaitGroup
+	var sendErr, recvErr error
+	wg.Add(2)
+
+	relay := func(dst, src net.Conn, counter *int64, errp *error) {
+		defer wg.Done()
+
+		var r io.Reader = src
+		if idleTimeout > 0 {
+			r = &idleReader{conn: src, t: t}
+		}
+		n, err := io.Copy(dst, r)
+		atomic.AddInt64(counter, n)
+
+		if err != nil && atomic.LoadInt32(&t.stopped) == 0 {
+			*errp = err
+			// 出错时让另一个方向也尽快结束
+			t.stop(client, target)
+			return
+		}
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			// 不支持半关闭，只能结束整个隧道
+			t.stop(client, target)
+		}
+	}
+
+	go relay(target, client, &stats.Sent, &sendErr)
+	go relay(client, target, &stats.Received, &recvErr)
+	wg.Wait()
+	client.SetReadDeadline(time.Time{})
+
+	if sendErr != nil {
+		return sendErr
+	}
+	return recvErr
+}
+
+type tunnelState struct {
+	idleTimeout time.Duration
+	lastActive  int64
+	stopped     int32
+}
+
+func (t *tunnelState) stop(client, target net.Conn) {
+	atomic.StoreInt32(&t.stopped, 1)
+	client.SetReadDeadline(time.Now())
+	target.SetReadDeadline(time.Now())
+}
+
+func (t *tunnelState) touch() {
+	atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+}
+
+func (t *tunnelState) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&t.lastActive))
+}
+
+// idleReader 在两个方向都没有数据超过 idleTimeout 时返回超时错误
+type idleReader struct {
+	conn net.Conn
+	t    *tunnelState
+}
+
+func (r *idleReader) Read(b []byte) (n int, err error) {
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(r.t.idleTimeout))
+		n, err = r.conn.Read(b)
+		if n > 0 {
+			r.t.touch()
+			return
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() &&
+			atomic.LoadInt32(&r.t.stopped) == 0 && r.t.idle() < r.t.idleTimeout {
+			// 另一个方向还有数据，继续等待
+			continue
+		}
+		return
+	}
+}
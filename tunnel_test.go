@@ -0,0 +1,138 @@
+package http1
+
+import (
+	"bufio"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startTunnelProxy 启动一个只处理 CONNECT 的代理，每个隧道结束后把结果发到 results
+func startTunnelProxy(t *testing.T, opts *TunnelOptions) (ln net.Listener, results chan error, stats chan TunnelStats) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	results = make(chan error, 10)
+	stats = make(chan TunnelStats, 10)
+
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, e := ReadRequest(br)
+				if e != nil {
+					results <- e
+					return
+				}
+				s, e := Tunnel(req, conn, br, opts)
+				stats <- s
+				results <- e
+			}()
+		}
+	}()
+	return
+}
+
+func Test_Tunnel(t *testing.T) {
+	// 目标服务：回显直到对方半关闭，然后写入结束标记
+	target, _ := net.Listen("tcp4", "127.0.0.1:0")
+	defer target.Close()
+	go func() {
+		conn, e := target.Accept()
+		if e != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+		conn.Write([]byte("|done"))
+	}()
+
+	proxy, results, stats := startTunnelProxy(t, &TunnelOptions{IdleTimeout: time.Second})
+	defer proxy.Close()
+
+	conn, e := net.Dial("tcp4", proxy.Addr().String())
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	// CONNECT 之后立即发送的数据会先被 bufio.Reader 读入
+	addr := target.Addr().String()
+	conn.Write([]byte("CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n\r\nhello"))
+
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	assert.Nil(t, e)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	conn.Write([]byte(" world"))
+	conn.(*net.TCPConn).CloseWrite()
+
+	b, e := ioutil.ReadAll(br)
+	assert.Nil(t, e)
+	assert.Equal(t, "hello world|done", string(b))
+
+	assert.Nil(t, <-results)
+	assert.Equal(t, TunnelStats{Sent: 11, Received: 16}, <-stats)
+}
+
+func Test_Tunnel_IdleTimeout(t *testing.T) {
+	target, _ := net.Listen("tcp4", "127.0.0.1:0")
+	defer target.Close()
+	go func() {
+		conn, e := target.Accept()
+		if e == nil {
+			// 不发送任何数据
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	proxy, results, _ := startTunnelProxy(t, &TunnelOptions{IdleTimeout: 100 * time.Millisecond})
+	defer proxy.Close()
+
+	conn, e := net.Dial("tcp4", proxy.Addr().String())
+	assert.Nil(t, e)
+	defer conn.Close()
+	conn.Write([]byte("CONNECT " + target.Addr().String() + " HTTP/1.1\r\n\r\n"))
+
+	begin := time.Now()
+	e = <-results
+	ne, ok := e.(net.Error)
+	assert.True(t, ok && ne.Timeout())
+	assert.True(t, time.Since(begin) < time.Second)
+}
+
+type failingDialer struct{}
+
+func (failingDialer) Dial(network, addr string) (net.Conn, error) {
+	return nil, errors.New("dial refused: " + addr)
+}
+
+func Test_Tunnel_DialError(t *testing.T) {
+	proxy, results, _ := startTunnelProxy(t, &TunnelOptions{Dialer: failingDialer{}})
+	defer proxy.Close()
+
+	conn, e := net.Dial("tcp4", proxy.Addr().String())
+	assert.Nil(t, e)
+	defer conn.Close()
+	conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\n\r\n"))
+
+	resp, e := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.EqualError(t, <-results, "dial refused: example.com:443")
+
+	req := NewRequest("GET", "http://example.com/", nil)
+	_, e = Tunnel(req, conn, nil, nil)
+	assert.Equal(t, ErrNotConnect, e)
+}
@@ -0,0 +1,95 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+var bUpgrade = []byte("Upgrade")
+
+var errHeaderWritten = errors.New("http1: response header already written")
+
+// IsUpgrade reports whether the client asks to switch protocols, that is
+// "Connection: Upgrade" together with a non-empty Upgrade header.
+func (m *Request) IsUpgrade() bool {
+	return m.Header.ProtoAtLeast(1, 1) &&
+		m.Header.HasToken(bConnection, bUpgrade) &&
+		len(bytes.TrimSpace(m.Header.Get(bUpgrade))) > 0
+}
+
+// UpgradeProtocols returns the protocols listed in the Upgrade headers, in
+// order of preference, e.g. ["websocket"] or ["h2c"].
+func (m *Request) UpgradeProtocols() (protocols []string) {
+	m.Header.VisitFor(bUpgrade, func(i int, value []byte) bool {
+		for _, p := range bytes.Split(value, []byte(",")) {
+			if p = bytes.TrimSpace(p); len(p) > 0 {
+				protocols = append(protocols, string(p))
+			}
+		}
+		return true
+	})
+	return
+}
+
+// HasUpgradeProtocol reports whether protocol is one of UpgradeProtocols,
+// compared case-insensitively.
+func (m *Request) HasUpgradeProtocol(protocol string) bool {
+	return m.IsUpgrade() && m.Header.HasToken(bUpgrade, []byte(protocol))
+}
+
+// WriteSwitchingProtocols writes a "101 Switching Protocols" response for
+// protocol to w. Headers in h (which may be nil) are sent along, e.g.
+// Sec-WebSocket-Accept.
+func WriteSwitchingProtocols(w io.Writer, protocol string, h *ResponseHeader) (err error) {
+	if h == nil {
+		h = NewResponseHeader()
+	}
+	h.StatusCode = http.StatusSwitchingProtocols
+	h.Set(bConnection, bUpgrade)
+	h.Set(bUpgrade, []byte(protocol))
+
+	if _, err = w.Write(h.Bytes()); err == nil {
+		if bw, ok := w.(*bufio.Writer); ok {
+			err = bw.Flush()
+		}
+	}
+	return
+}
+
+// HijackConn returns conn along with the bytes br has already read from it
+// but not yet consumed, e.g. the first frames a WebSocket client sent right
+// after its handshake. br must not be used afterwards.
+func HijackConn(conn net.Conn, br *bufio.Reader) (net.Conn, []byte) {
+	if br == nil || br.Buffered() == 0 {
+		return conn, nil
+	}
+	buffered := make([]byte, br.Buffered())
+	br.Read(buffered)
+	return conn, buffered
+}
+
+// SwitchProtocols sends "101 Switching Protocols" for protocol with the
+// headers already set on w.Header, then hijacks the connection like
+// HijackConn. The request must not be used once the handler returns.
+func (w *ResponseWriter) SwitchProtocols(protocol string) (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrAlreadyHijacked
+	}
+	if w.headerWritten {
+		return nil, nil, errHeaderWritten
+	}
+	w.headerWritten = true
+	if w.err = WriteSwitchingProtocols(w.bw, protocol, w.Header); w.err != nil {
+		return nil, nil, w.err
+	}
+
+	w.hijacked = true
+	w.conn.SetReadDeadline(time.Time{})
+	conn, buffered := HijackConn(w.conn, w.br)
+	return conn, buffered, nil
+}
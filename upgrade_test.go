@@ -0,0 +1,99 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Request_IsUpgrade(t *testing.T) {
+	req, e := ReadRequest(bufio.NewReader(strings.NewReader(strings.Join([]string{
+		"GET /chat HTTP/1.1",
+		"Host: a",
+		"Connection: keep-alive, Upgrade",
+		"Upgrade: websocket",
+		"Upgrade: h2c, foo/2",
+		"", "",
+	}, "\r\n"))))
+	assert.Nil(t, e)
+	assert.True(t, req.IsUpgrade())
+	assert.Equal(t, []string{"websocket", "h2c", "foo/2"}, req.UpgradeProtocols())
+	assert.True(t, req.HasUpgradeProtocol("WebSocket"))
+	assert.True(t, req.HasUpgradeProtocol("h2c"))
+	assert.False(t, req.HasUpgradeProtocol("h2"))
+	ReleaseRequest(req)
+
+	// 缺少 Connection: Upgrade
+	req, e = ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nUpgrade: websocket\r\n\r\n")))
+	assert.Nil(t, e)
+	assert.False(t, req.IsUpgrade())
+	ReleaseRequest(req)
+}
+
+func Test_WriteSwitchingProtocols(t *testing.T) {
+	w := bytes.NewBuffer(nil)
+	h := NewResponseHeader()
+	h.Add([]byte("Sec-Websocket-Accept"), []byte("xyz"))
+	assert.Nil(t, WriteSwitchingProtocols(w, "websocket", h))
+
+	resp, e := http.ReadResponse(bufio.NewReader(w), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "xyz", resp.Header.Get("Sec-Websocket-Accept"))
+}
+
+func Test_ResponseWriter_SwitchProtocols(t *testing.T) {
+	s, addr := startNativeServer(t, func(w *ResponseWriter, req *Request) {
+		if !req.HasUpgradeProtocol("echo") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, buffered, e := w.SwitchProtocols("echo")
+		assert.Nil(t, e)
+
+		go func() {
+			defer conn.Close()
+			// 握手之后客户端立即发送的数据可能已经在 bufio.Reader 里
+			io.Copy(conn, io.MultiReader(bytes.NewReader(buffered), io.LimitReader(conn, int64(4-len(buffered)))))
+		}()
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nPING"))
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+	b := make([]byte, 4)
+	_, e = io.ReadFull(br, b)
+	assert.Nil(t, e)
+	assert.Equal(t, "PING", string(b))
+}
+
+func Test_HijackConn(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	go left.Write([]byte("GET / HTTP/1.1\r\n\r\nrest"))
+
+	br := bufio.NewReader(right)
+	req, e := ReadRequest(br)
+	assert.Nil(t, e)
+	ReleaseRequest(req)
+
+	conn, buffered := HijackConn(right, br)
+	assert.Equal(t, right, conn)
+	assert.Equal(t, "rest", string(buffered))
+}
@@ -21,11 +21,35 @@ func (c *restoredConn) Read(b []byte) (n int, e error) {
 	return c.r.Read(b)
 }
 
+// RestoreConn returns a conn whose reads yield req, then the bytes br has
+// buffered, then the rest of conn, see ReplayConn. If req cannot be
+// replayed, reads from the returned conn fail with the error of ReplayConn.
 func RestoreConn(conn net.Conn, br *bufio.Reader, req *Request) net.Conn {
+	c, err := ReplayConn(conn, br, req)
+	if err != nil {
+		return &restoredConn{Conn: conn, r: &errReader{err}}
+	}
+	return c
+}
+
+// ReplayConn returns a conn whose reads yield req, then the bytes br has
+// buffered, then the rest of conn. If EnableReplay was called on req, it is
+// replayed exactly as received together with the body bytes already read,
+// and ErrReplayOverflow is returned if they no longer fit; otherwise
+// req.Header.Bytes() is replayed and the body must not have been read.
+func ReplayConn(conn net.Conn, br *bufio.Reader, req *Request) (net.Conn, error) {
 	var readers []io.Reader
 
 	if req != nil {
-		readers = append(readers, bytes.NewBuffer(req.Header.Bytes()))
+		b, err := req.ReplayBytes()
+		switch err {
+		case nil:
+			readers = append(readers, bytes.NewReader(b))
+		case ErrReplayDisabled:
+			readers = append(readers, bytes.NewBuffer(req.Header.Bytes()))
+		default:
+			return nil, err
+		}
 	}
 	if br != nil && br.Buffered() > 0 {
 		readers = append(readers, io.LimitReader(br, int64(br.Buffered())))
@@ -35,5 +59,13 @@ func RestoreConn(conn net.Conn, br *bufio.Reader, req *Request) net.Conn {
 	return &restoredConn{
 		Conn: conn,
 		r:    io.MultiReader(readers...),
-	}
+	}, nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(b []byte) (int, error) {
+	return 0, r.err
 }
@@ -0,0 +1,500 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcodes (RFC 6455 5.2)
+const (
+	ContinuationFrame = 0x0
+	TextMessage       = 0x1
+	BinaryMessage     = 0x2
+	CloseMessage      = 0x8
+	PingMessage       = 0x9
+	PongMessage       = 0xA
+)
+
+// Close codes (RFC 6455 7.4.1)
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseTLSHandshake            = 1015
+)
+
+const (
+	finalBit = 0x80
+	rsvBits  = 0x70
+	maskBit  = 0x80
+
+	maxControlPayload = 125
+)
+
+var (
+	ErrUnmaskedFrame     = errors.New("websocket: client frame is not masked")
+	ErrMaskedFrame       = errors.New("websocket: server frame is masked")
+	ErrReservedBits      = errors.New("websocket: reserved bits set without negotiated extension")
+	ErrBadOpcode         = errors.New("websocket: unknown opcode")
+	ErrControlTooLarge   = errors.New("websocket: control frame payload exceeds 125 bytes")
+	ErrControlFragmented = errors.New("websocket: control frame is fragmented")
+	ErrUnexpectedCont    = errors.New("websocket: continuation frame without a message")
+	ErrExpectedCont      = errors.New("websocket: new data frame before the previous message ended")
+	ErrFrameTooLarge     = errors.New("websocket: frame length too large")
+	ErrMessageTooLarge   = errors.New("websocket: message too large")
+	ErrInvalidUTF8       = errors.New("websocket: invalid UTF-8 in text message")
+	ErrCloseSent         = errors.New("websocket: close frame already sent")
+)
+
+// DefaultMaxMessageSize is used when Conn.MaxMessageSize is 0.
+const DefaultMaxMessageSize = 32 << 20
+
+// payloadChunk 是一次为payload分配的最大长度，长度字段由对方给出，不能直接按它分配
+const payloadChunk = 64 << 10
+
+// CloseError is returned by ReadMessage once the peer sent a close frame.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Frame is a single WebSocket frame with its payload already unmasked.
+type Frame struct {
+	Fin     bool
+	Rsv     byte // RSV1-3，位置和线上一致（0x40, 0x20, 0x10）
+	Opcode  byte
+	Masked  bool
+	Payload []byte
+}
+
+func (f *Frame) IsControl() bool {
+	return f.Opcode&0x8 != 0
+}
+
+// Conn is a WebSocket connection over a hijacked net.Conn. Frames are
+// masked when sent by the client and checked for masking by the server.
+// Reads must come from one goroutine; writes may be concurrent.
+type Conn struct {
+	// MaxMessageSize limits the size of a frame and of an assembled message
+	// read from the peer. 0 means DefaultMaxMessageSize, a negative value no
+	// limit.
+	MaxMessageSize int64
+	// AllowedRsv are the RSV bits negotiated by extensions.
+	AllowedRsv byte
+
+	// PingHandler is called for each ping; the default replies with a pong.
+	PingHandler func(data []byte) error
+	// PongHandler is called for each pong; the default ignores it.
+	PongHandler func(data []byte) error
+
+	conn   net.Conn
+	br     *bufio.Reader
+	server bool
+
+	// 读状态：分片消息
+	readOpcode byte
+	readBuf    []byte
+	inMessage  bool
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+	header    [14]byte
+	maskBuf   []byte
+}
+
+// NewConn wraps a connection whose handshake has completed. buffered are
+// the bytes already read past the handshake (see http1.HijackConn).
+func NewConn(conn net.Conn, buffered []byte, server bool) *Conn {
+	return newConn(conn, buffered, server)
+}
+
+func newConn(conn net.Conn, buffered []byte, server bool) *Conn {
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return &Conn{
+		conn:   conn,
+		br:     bufio.NewReader(r),
+		bw:     bufio.NewWriter(conn),
+		server: server,
+	}
+}
+
+func (c *Conn) NetConn() net.Conn                  { return c.conn }
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadFrame reads the next frame as it is, without handling control frames
+// or assembling fragments. It only checks what RFC 6455 requires of any
+// single frame, so a proxy can inspect and forward frames one by one.
+func (c *Conn) ReadFrame() (f *Frame, err error) {
+	var b [8]byte
+	if _, err = io.ReadFull(c.br, b[:2]); err != nil {
+		return
+	}
+
+	f = &Frame{
+		Fin:    b[0]&finalBit != 0,
+		Rsv:    b[0] & rsvBits,
+		Opcode: b[0] & 0x0f,
+		Masked: b[1]&maskBit != 0,
+	}
+
+	switch f.Opcode {
+	case ContinuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return nil, ErrBadOpcode
+	}
+	if f.Rsv&^c.AllowedRsv != 0 {
+		return nil, ErrReservedBits
+	}
+	if c.server && !f.Masked {
+		return nil, ErrUnmaskedFrame
+	}
+	if !c.server && f.Masked {
+		return nil, ErrMaskedFrame
+	}
+
+	length := uint64(b[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.br, b[:2]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, b[:8]); err != nil {
+			return nil, err
+		}
+		if length = binary.BigEndian.Uint64(b[:8]); length>>63 != 0 {
+			return nil, ErrFrameTooLarge
+		}
+	}
+
+	if f.IsControl() {
+		if length > maxControlPayload {
+			return nil, ErrControlTooLarge
+		}
+		if !f.Fin {
+			return nil, ErrControlFragmented
+		}
+	}
+	if max := c.maxMessageSize(); max >= 0 && length > uint64(max) {
+		return nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if f.Masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Payload, err = c.readPayload(length); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		maskBytes(mask, f.Payload)
+	}
+	return f, nil
+}
+
+// ReadMessage returns the next complete data message. Fragments are
+// assembled, pings are answered through PingHandler, and a close frame is
+// echoed back and returned as *CloseError.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	for {
+		var f *Frame
+		if f, err = c.ReadFrame(); err != nil {
+			c.failOnProtocolError(err)
+			return
+		}
+
+		switch f.Opcode {
+		case PingMessage:
+			if err = c.handlePing(f.Payload); err != nil {
+				return
+			}
+			continue
+		case PongMessage:
+			if c.PongHandler != nil {
+				if err = c.PongHandler(f.Payload); err != nil {
+					return
+				}
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.Payload)
+		case ContinuationFrame:
+			if !c.inMessage {
+				err = ErrUnexpectedCont
+				c.failOnProtocolError(err)
+				return
+			}
+		default:
+			if c.inMessage {
+				err = ErrExpectedCont
+				c.failOnProtocolError(err)
+				return
+			}
+			c.inMessage = true
+			c.readOpcode = f.Opcode
+			c.readBuf = c.readBuf[:0]
+		}
+
+		if max := c.maxMessageSize(); max >= 0 && int64(len(c.readBuf)+len(f.Payload)) > max {
+			c.inMessage = false
+			err = ErrMessageTooLarge
+			c.WriteClose(CloseMessageTooBig, "")
+			return
+		}
+		c.readBuf = append(c.readBuf, f.Payload...)
+		if !f.Fin {
+			continue
+		}
+
+		c.inMessage = false
+		data = append([]byte(nil), c.readBuf...)
+		if c.readOpcode == TextMessage && !utf8.Valid(data) {
+			err = ErrInvalidUTF8
+			c.WriteClose(CloseInvalidFramePayloadData, "")
+			return
+		}
+		return int(c.readOpcode), data, nil
+	}
+}
+
+func (c *Conn) maxMessageSize() int64 {
+	if c.MaxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+// readPayload 随着数据到达逐步扩大payload，对方声明了很大的长度却不发送时不会先分配
+func (c *Conn) readPayload(length uint64) ([]byte, error) {
+	n := length
+	if n > payloadChunk {
+		n = payloadChunk
+	}
+	payload := make([]byte, 0, n)
+	for uint64(len(payload)) < length {
+		if len(payload) == cap(payload) {
+			payload = append(payload, 0)[:len(payload)]
+		}
+		end := cap(payload)
+		if uint64(end) > length {
+			end = int(length)
+		}
+		k, err := io.ReadFull(c.br, payload[len(payload):end])
+		payload = payload[:len(payload)+k]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+func (c *Conn) failOnProtocolError(err error) {
+	switch err {
+	case ErrUnmaskedFrame, ErrMaskedFrame, ErrReservedBits, ErrBadOpcode, ErrControlTooLarge,
+		ErrControlFragmented, ErrUnexpectedCont, ErrExpectedCont, ErrFrameTooLarge:
+		c.WriteClose(CloseProtocolError, "")
+	case ErrMessageTooLarge:
+		c.WriteClose(CloseMessageTooBig, "")
+	}
+}
+
+func (c *Conn) handlePing(data []byte) error {
+	if c.PingHandler != nil {
+		return c.PingHandler(data)
+	}
+	err := c.WriteControl(PongMessage, data)
+	if err == ErrCloseSent {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		c.WriteClose(CloseProtocolError, "")
+		return ce
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+		if !validCloseCode(ce.Code) || !utf8.Valid(payload[2:]) {
+			c.WriteClose(CloseProtocolError, "")
+			return ce
+		}
+	}
+
+	// 回应对方的 close，完成关闭握手
+	if ce.Code == CloseNoStatusReceived {
+		c.writeFrame(true, 0, CloseMessage, nil)
+	} else {
+		c.WriteClose(ce.Code, "")
+	}
+	return ce
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// FormatCloseMessage builds the payload of a close frame.
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	b := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	copy(b[2:], text)
+	return b
+}
+
+// WriteMessage sends data as a single unfragmented message.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(true, 0, byte(opcode), data)
+}
+
+// WriteFragmented sends data as a message split into frames of at most
+// fragmentSize bytes.
+func (c *Conn) WriteFragmented(opcode int, data []byte, fragmentSize int) error {
+	if fragmentSize <= 0 || len(data) <= fragmentSize {
+		return c.WriteMessage(opcode, data)
+	}
+	op := byte(opcode)
+	for len(data) > 0 {
+		n := fragmentSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := c.writeFrame(n == len(data), 0, op, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		op = ContinuationFrame
+	}
+	return nil
+}
+
+// WriteFrame sends f, masking the payload if c is a client. Masked is ignored.
+func (c *Conn) WriteFrame(f *Frame) error {
+	if f.IsControl() && (len(f.Payload) > maxControlPayload || !f.Fin) {
+		if !f.Fin {
+			return ErrControlFragmented
+		}
+		return ErrControlTooLarge
+	}
+	return c.writeFrame(f.Fin, f.Rsv, f.Opcode, f.Payload)
+}
+
+// WriteControl sends a ping, pong or close frame.
+func (c *Conn) WriteControl(opcode int, data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrControlTooLarge
+	}
+	return c.writeFrame(true, 0, byte(opcode), data)
+}
+
+// WriteClose starts (or completes) the closing handshake. No data frame can
+// be sent afterwards.
+func (c *Conn) WriteClose(code int, text string) error {
+	return c.WriteControl(CloseMessage, FormatCloseMessage(code, text))
+}
+
+func (c *Conn) writeFrame(fin bool, rsv, opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	h := c.header[:2]
+	h[0] = rsv | opcode
+	if fin {
+		h[0] |= finalBit
+	}
+
+	n := len(payload)
+	switch {
+	case n <= 125:
+		h[1] = byte(n)
+	case n <= 0xffff:
+		h[1] = 126
+		h = h[:4]
+		binary.BigEndian.PutUint16(h[2:], uint16(n))
+	default:
+		h[1] = 127
+		h = h[:10]
+		binary.BigEndian.PutUint64(h[2:], uint64(n))
+	}
+
+	if !c.server {
+		// 客户端发出的帧必须使用随机掩码
+		var mask [4]byte
+		rand.Read(mask[:])
+		h[1] |= maskBit
+		h = append(h, mask[:]...)
+
+		c.maskBuf = append(c.maskBuf[:0], payload...)
+		maskBytes(mask, c.maskBuf)
+		payload = c.maskBuf
+	}
+
+	c.bw.Write(h)
+	c.bw.Write(payload)
+	return c.bw.Flush()
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i&3]
+	}
+}
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// 使用 TCP 而不是 net.Pipe，写操作不会因为对方没有读而阻塞
+func pipeConns() (server, client *Conn) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		panic(e)
+	}
+	defer ln.Close()
+
+	right, e := net.Dial("tcp4", ln.Addr().String())
+	if e != nil {
+		panic(e)
+	}
+	left, e := ln.Accept()
+	if e != nil {
+		panic(e)
+	}
+	return NewConn(left, nil, true), NewConn(right, nil, false)
+}
+
+func Test_Conn_Fragmentation(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	msg := []byte(strings.Repeat("0123456789", 100))
+	client.WriteFragmented(BinaryMessage, msg, 64)
+
+	op, data, e := server.ReadMessage()
+	assert.Nil(t, e)
+	assert.Equal(t, BinaryMessage, op)
+	assert.Equal(t, msg, data)
+
+	// 逐帧读取时可以看到每个分片
+	client.WriteFragmented(TextMessage, []byte("abcdef"), 4)
+	f, e := server.ReadFrame()
+	assert.Nil(t, e)
+	assert.Equal(t, &Frame{Fin: false, Opcode: TextMessage, Masked: true, Payload: []byte("abcd")}, f)
+	f, e = server.ReadFrame()
+	assert.Nil(t, e)
+	assert.Equal(t, &Frame{Fin: true, Opcode: ContinuationFrame, Masked: true, Payload: []byte("ef")}, f)
+}
+
+func Test_Conn_LargeFrames(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		msg := bytes.Repeat([]byte{'x'}, n)
+		go server.WriteMessage(BinaryMessage, msg)
+		_, data, e := client.ReadMessage()
+		assert.Nil(t, e)
+		assert.Equal(t, n, len(data))
+	}
+}
+
+func Test_Conn_PingPong(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	var pong string
+	client.PongHandler = func(data []byte) error {
+		pong = string(data)
+		return nil
+	}
+
+	go func() {
+		// 服务端在 ReadMessage 中自动回复 pong，然后回显消息
+		op, data, _ := server.ReadMessage()
+		server.WriteMessage(op, data)
+	}()
+
+	// ping 插在分片消息中间
+	client.WriteFrame(&Frame{Opcode: TextMessage, Payload: []byte("he")})
+	client.WriteControl(PingMessage, []byte("are you there"))
+	client.WriteFrame(&Frame{Fin: true, Opcode: ContinuationFrame, Payload: []byte("llo")})
+
+	op, data, e := client.ReadMessage()
+	assert.Nil(t, e)
+	assert.Equal(t, TextMessage, op)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "are you there", pong)
+}
+
+func Test_Conn_Close(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	client.WriteClose(CloseGoingAway, "leaving")
+	_, _, e := server.ReadMessage()
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Text: "leaving"}, e)
+	assert.Equal(t, ErrCloseSent, server.WriteMessage(TextMessage, []byte("x")))
+
+	// 客户端收到服务端回应的 close
+	_, _, e = client.ReadMessage()
+	assert.Equal(t, &CloseError{Code: CloseGoingAway}, e)
+}
+
+func Test_Conn_ProtocolErrors(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	// 服务端不能接受未加掩码的帧
+	raw := client.conn
+	raw.Write([]byte{finalBit | TextMessage, 2, 'h', 'i'})
+	_, _, e := server.ReadMessage()
+	assert.Equal(t, ErrUnmaskedFrame, e)
+
+	// 服务端随后发送 1002 关闭帧
+	f, e := client.ReadFrame()
+	assert.Nil(t, e)
+	assert.Equal(t, byte(CloseMessage), f.Opcode)
+	assert.Equal(t, FormatCloseMessage(CloseProtocolError, ""), f.Payload)
+}
+
+func Test_Conn_InvalidUTF8(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	client.WriteMessage(TextMessage, []byte{0xff, 0xfe})
+	_, _, e := server.ReadMessage()
+	assert.Equal(t, ErrInvalidUTF8, e)
+}
+
+func Test_Conn_MaxMessageSize(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+	server.MaxMessageSize = 10
+
+	client.WriteFragmented(BinaryMessage, make([]byte, 16), 8)
+	_, _, e := server.ReadMessage()
+	assert.Equal(t, ErrMessageTooLarge, e)
+}
+
+func Test_Conn_OversizedLength(t *testing.T) {
+	server, client := pipeConns()
+	defer server.Close()
+	defer client.Close()
+
+	// 声明 1TB 的payload，但不发送
+	header := []byte{0x82, 0x80 | 127, 0, 0, 1, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+	client.conn.Write(header)
+	_, _, e := server.ReadMessage()
+	assert.Equal(t, ErrMessageTooLarge, e)
+
+	// 没有限制时按到达的数据读取，不会先分配声明的长度
+	server, client = pipeConns()
+	defer server.Close()
+	server.MaxMessageSize = -1
+	client.conn.Write(header)
+	client.conn.Write([]byte("abc"))
+	client.Close()
+	_, e = server.ReadFrame()
+	assert.Equal(t, io.ErrUnexpectedEOF, e)
+}
@@ -0,0 +1,291 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/lib-go/http1"
+)
+
+var (
+	ErrBadMethod      = errors.New("websocket: method must be GET")
+	ErrNotUpgrade     = errors.New("websocket: missing Connection: Upgrade or Upgrade: websocket")
+	ErrBadVersion     = errors.New("websocket: unsupported Sec-WebSocket-Version")
+	ErrBadKey         = errors.New("websocket: invalid Sec-WebSocket-Key")
+	ErrBadOrigin      = errors.New("websocket: origin not allowed")
+	ErrBadAccept      = errors.New("websocket: invalid Sec-WebSocket-Accept")
+	ErrBadHandshake   = errors.New("websocket: bad handshake response")
+	ErrBadSubprotocol = errors.New("websocket: server selected a subprotocol that was not offered")
+)
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	version    = "13"
+)
+
+var (
+	bSecWebSocketKey        = []byte("Sec-Websocket-Key")
+	bSecWebSocketVersion    = []byte("Sec-Websocket-Version")
+	bSecWebSocketProtocol   = []byte("Sec-Websocket-Protocol")
+	bSecWebSocketExtensions = []byte("Sec-Websocket-Extensions")
+	bSecWebSocketAccept     = []byte("Sec-Websocket-Accept")
+	bOrigin                 = []byte("Origin")
+	bHost                   = []byte("Host")
+	bConnection             = []byte("Connection")
+	bUpgrade                = []byte("Upgrade")
+	bWebSocket              = []byte("websocket")
+)
+
+// Extension is one entry of Sec-WebSocket-Extensions,
+// e.g. "permessage-deflate; client_max_window_bits".
+type Extension struct {
+	Name   string
+	Params []ExtensionParam // in the order they were sent
+}
+
+// ExtensionParam is a parameter of an Extension. Value is empty for
+// parameters without a value.
+type ExtensionParam struct {
+	Name, Value string
+}
+
+// Handshake holds the validated opening handshake of a client.
+type Handshake struct {
+	Key        string
+	Version    string
+	Origin     string
+	Protocols  []string
+	Extensions []Extension
+}
+
+// ParseHandshake validates the opening handshake of req (RFC 6455 4.2.1).
+// Header keys are matched in the normalized form produced by RequestHeader.Read.
+func ParseHandshake(req *http1.Request) (hs *Handshake, err error) {
+	h := req.Header
+	if !bytes.Equal(h.Method, []byte(http.MethodGet)) {
+		return nil, ErrBadMethod
+	}
+	if !req.IsUpgrade() || !h.HasToken(bUpgrade, bWebSocket) {
+		return nil, ErrNotUpgrade
+	}
+	if !h.HasToken(bSecWebSocketVersion, []byte(version)) {
+		return nil, ErrBadVersion
+	}
+
+	hs = &Handshake{
+		Key:     string(bytes.TrimSpace(h.Get(bSecWebSocketKey))),
+		Version: version,
+		Origin:  string(h.Get(bOrigin)),
+	}
+	if key, e := base64.StdEncoding.DecodeString(hs.Key); e != nil || len(key) != 16 {
+		return nil, ErrBadKey
+	}
+
+	h.VisitFor(bSecWebSocketProtocol, func(i int, value []byte) bool {
+		hs.Protocols = append(hs.Protocols, splitTokens(string(value))...)
+		return true
+	})
+	h.VisitFor(bSecWebSocketExtensions, func(i int, value []byte) bool {
+		hs.Extensions = append(hs.Extensions, ParseExtensions(string(value))...)
+		return true
+	})
+	return hs, nil
+}
+
+// ParseExtensions parses the value of a Sec-WebSocket-Extensions header.
+func ParseExtensions(value string) (extensions []Extension) {
+	for _, item := range strings.Split(value, ",") {
+		params := strings.Split(item, ";")
+		name := strings.TrimSpace(params[0])
+		if name == "" {
+			continue
+		}
+		ext := Extension{Name: name}
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			k, v := p, ""
+			if i := strings.IndexByte(p, '='); i != -1 {
+				k, v = strings.TrimSpace(p[:i]), strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+			}
+			ext.Params = append(ext.Params, ExtensionParam{Name: k, Value: v})
+		}
+		extensions = append(extensions, ext)
+	}
+	return
+}
+
+func (e Extension) String() string {
+	s := e.Name
+	for _, p := range e.Params {
+		s += "; " + p.Name
+		if p.Value != "" {
+			s += "=" + p.Value
+		}
+	}
+	return s
+}
+
+func splitTokens(value string) (tokens []string) {
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return
+}
+
+// AcceptKey computes Sec-WebSocket-Accept for the client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrader performs the server side of the opening handshake.
+type Upgrader struct {
+	// Subprotocols the server supports, in order of preference.
+	Subprotocols []string
+
+	// CheckOrigin returns false to reject the handshake with 403. If nil,
+	// any origin is accepted.
+	CheckOrigin func(req *http1.Request, hs *Handshake) bool
+
+	// NegotiateExtensions returns the extensions the server agrees to. No
+	// extension is implemented by Conn, so accepted ones must be handled by
+	// the caller through the RSV bits of Frame.
+	NegotiateExtensions func(offered []Extension) []Extension
+
+	// MaxMessageSize is set on the accepted Conn, see Conn.MaxMessageSize.
+	MaxMessageSize int64
+}
+
+// Upgrade validates req, replies "101 Switching Protocols" and returns the
+// WebSocket connection. On failure an error response has been set on w.
+func (u *Upgrader) Upgrade(w *http1.ResponseWriter, req *http1.Request) (*Conn, error) {
+	hs, err := ParseHandshake(req)
+	if err != nil {
+		if err == ErrBadVersion {
+			w.Header.Set(bSecWebSocketVersion, []byte(version))
+			w.WriteHeader(http.StatusUpgradeRequired)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return nil, err
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(req, hs) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+
+	w.Header.Set(bSecWebSocketAccept, []byte(AcceptKey(hs.Key)))
+	if p := u.selectSubprotocol(hs.Protocols); p != "" {
+		w.Header.Set(bSecWebSocketProtocol, []byte(p))
+	}
+	if u.NegotiateExtensions != nil && len(hs.Extensions) > 0 {
+		if accepted := u.NegotiateExtensions(hs.Extensions); len(accepted) > 0 {
+			w.Header.Set(bSecWebSocketExtensions, []byte(joinExtensions(accepted)))
+		}
+	}
+
+	conn, buffered, err := w.SwitchProtocols("websocket")
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(conn, buffered, true)
+	c.MaxMessageSize = u.MaxMessageSize
+	return c, nil
+}
+
+func (u *Upgrader) selectSubprotocol(offered []string) string {
+	for _, s := range u.Subprotocols {
+		for _, o := range offered {
+			if s == o {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func joinExtensions(extensions []Extension) string {
+	s := make([]string, len(extensions))
+	for i, e := range extensions {
+		s[i] = e.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// NewClientRequest builds the opening handshake for uri (origin-form, e.g.
+// "/chat") on host and returns it with the generated Sec-WebSocket-Key.
+func NewClientRequest(uri, host string, protocols ...string) (req *http1.Request, key string) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key = base64.StdEncoding.EncodeToString(nonce[:])
+
+	req = http1.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Add(bHost, []byte(host))
+	req.Header.Add(bConnection, bUpgrade)
+	req.Header.Add(bUpgrade, bWebSocket)
+	req.Header.Add(bSecWebSocketVersion, []byte(version))
+	req.Header.Add(bSecWebSocketKey, []byte(key))
+	if len(protocols) > 0 {
+		req.Header.Add(bSecWebSocketProtocol, []byte(strings.Join(protocols, ", ")))
+	}
+	return
+}
+
+// ClientHandshake sends req (from NewClientRequest, key being its
+// Sec-WebSocket-Key) over conn and checks the server's response. resp can
+// be released with http1.ReleaseResponse; when the handshake is rejected its
+// Body reads the body of the rejection from conn.
+func ClientHandshake(conn net.Conn, req *http1.Request, key string) (c *Conn, resp *http1.Response, err error) {
+	if _, err = req.WriteTo(conn); err != nil {
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	resp = http1.AcquireResponse()
+	if err = resp.Read(br, req); err != nil {
+		http1.ReleaseResponse(resp)
+		return nil, nil, err
+	}
+	h := resp.Header
+	if h.StatusCode != http.StatusSwitchingProtocols ||
+		!bytes.EqualFold(h.Get(bUpgrade), bWebSocket) ||
+		!h.HasToken(bConnection, bUpgrade) {
+		return nil, resp, ErrBadHandshake
+	}
+	if string(h.Get(bSecWebSocketAccept)) != AcceptKey(key) {
+		return nil, resp, ErrBadAccept
+	}
+	if p := string(h.Get(bSecWebSocketProtocol)); p != "" {
+		offered := false
+		req.Header.VisitFor(bSecWebSocketProtocol, func(i int, value []byte) bool {
+			offered = headerHasToken(string(value), p)
+			return !offered
+		})
+		if !offered {
+			return nil, resp, ErrBadSubprotocol
+		}
+	}
+
+	_, buffered := http1.HijackConn(conn, br)
+	return newConn(conn, buffered, false), resp, nil
+}
+
+func headerHasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"bufio"
+	"github.com/lib-go/http1"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func readRequest(lines ...string) *http1.Request {
+	req, e := http1.ReadRequest(bufio.NewReader(strings.NewReader(strings.Join(lines, "\r\n") + "\r\n\r\n")))
+	if e != nil {
+		panic(e)
+	}
+	return req
+}
+
+func Test_AcceptKey(t *testing.T) {
+	// RFC 6455 1.3 中的例子
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func Test_ParseHandshake(t *testing.T) {
+	hs, e := ParseHandshake(readRequest(
+		"GET /chat HTTP/1.1",
+		"Host: server.example.com",
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+		"Origin: http://example.com",
+		"Sec-WebSocket-Protocol: chat, superchat",
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits, x-foo; b=\"2\"; a=1",
+		"Sec-WebSocket-Version: 13",
+	))
+	assert.Nil(t, e)
+	assert.Equal(t, "dGhlIHNhbXBsZSBub25jZQ==", hs.Key)
+	assert.Equal(t, "http://example.com", hs.Origin)
+	assert.Equal(t, []string{"chat", "superchat"}, hs.Protocols)
+	assert.Equal(t, []Extension{
+		{Name: "permessage-deflate", Params: []ExtensionParam{{Name: "client_max_window_bits"}}},
+		{Name: "x-foo", Params: []ExtensionParam{{Name: "b", Value: "2"}, {Name: "a", Value: "1"}}},
+	}, hs.Extensions)
+	// 参数按收到的顺序输出
+	assert.Equal(t, "x-foo; b=2; a=1", hs.Extensions[1].String())
+
+	_, e = ParseHandshake(readRequest("POST /chat HTTP/1.1", "Upgrade: websocket", "Connection: Upgrade"))
+	assert.Equal(t, ErrBadMethod, e)
+
+	_, e = ParseHandshake(readRequest("GET /chat HTTP/1.1", "Upgrade: h2c", "Connection: Upgrade"))
+	assert.Equal(t, ErrNotUpgrade, e)
+
+	_, e = ParseHandshake(readRequest("GET /chat HTTP/1.1", "Upgrade: websocket", "Connection: Upgrade",
+		"Sec-WebSocket-Version: 8", "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ=="))
+	assert.Equal(t, ErrBadVersion, e)
+
+	_, e = ParseHandshake(readRequest("GET /chat HTTP/1.1", "Upgrade: websocket", "Connection: Upgrade",
+		"Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: c2hvcnQ="))
+	assert.Equal(t, ErrBadKey, e)
+}
+
+func startServer(t *testing.T, u *Upgrader, serve func(c *Conn)) (s *http1.Server, addr string) {
+	ln, e := net.Listen("tcp4", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s = &http1.Server{NativeHandler: http1.HandlerFunc(func(w *http1.ResponseWriter, req *http1.Request) {
+		c, e := u.Upgrade(w, req)
+		if e == nil {
+			go serve(c)
+		}
+	})}
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func Test_Upgrade(t *testing.T) {
+	u := &Upgrader{Subprotocols: []string{"superchat", "chat"}}
+	s, addr := startServer(t, u, func(c *Conn) {
+		defer c.Close()
+		for {
+			op, data, e := c.ReadMessage()
+			if e != nil {
+				return
+			}
+			c.WriteMessage(op, data)
+		}
+	})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	req, key := NewClientRequest("/chat", addr, "chat", "superchat")
+	c, resp, e := ClientHandshake(conn, req, key)
+	assert.Nil(t, e)
+	assert.Equal(t, "superchat", string(resp.Header.Get([]byte("Sec-Websocket-Protocol"))))
+	http1.ReleaseResponse(resp)
+
+	assert.Nil(t, c.WriteMessage(TextMessage, []byte("hello")))
+	op, data, e := c.ReadMessage()
+	assert.Nil(t, e)
+	assert.Equal(t, TextMessage, op)
+	assert.Equal(t, "hello", string(data))
+
+	assert.Nil(t, c.WriteClose(CloseNormalClosure, "bye"))
+	_, _, e = c.ReadMessage()
+	assert.Equal(t, &CloseError{Code: CloseNormalClosure}, e)
+}
+
+func Test_Upgrade_Rejected(t *testing.T) {
+	u := &Upgrader{CheckOrigin: func(req *http1.Request, hs *Handshake) bool {
+		return hs.Origin == "http://allowed.com"
+	}}
+	s, addr := startServer(t, u, func(c *Conn) {})
+	defer s.Close()
+
+	conn, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn.Close()
+
+	req, key := NewClientRequest("/", addr)
+	req.Header.Add([]byte("Origin"), []byte("http://evil.com"))
+	_, resp, e := ClientHandshake(conn, req, key)
+	assert.Equal(t, ErrBadHandshake, e)
+	assert.Equal(t, http.StatusForbidden, resp.Header.StatusCode)
+	http1.ReleaseResponse(resp)
+
+	conn2, e := net.Dial("tcp4", addr)
+	assert.Nil(t, e)
+	defer conn2.Close()
+	conn2.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 7\r\n\r\n"))
+	resp, e = http1.ReadResponse(bufio.NewReader(conn2), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, http.StatusUpgradeRequired, resp.Header.StatusCode)
+	assert.Equal(t, "13", string(resp.Header.Get([]byte("Sec-Websocket-Version"))))
+	http1.ReleaseResponse(resp)
+}